@@ -2,11 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/cckalen/intellichunk/internal/conversation"
 	"github.com/cckalen/intellichunk/internal/intellichunk"
 	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/session"
+	"github.com/gorilla/mux"
 )
 
 func ConversationHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +22,11 @@ func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"error": "Error Marshalling the request, provide valid ConversationRequest"}`))
 		return
 	}
+	if err := conversation.ValidateRequest(convReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
 
 	convResp, err := conversation.ClassConversation(convReq)
 	if err != nil {
@@ -30,6 +38,60 @@ func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(convResp)
 }
 
+// StreamConversationHandler answers like ConversationHandler with server-sent events, sending the answer while it is generated.
+// The events are retrieval, token, citations, suggestions and done, or error when the answer fails.
+func StreamConversationHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Streaming is not supported"}`))
+		return
+	}
+
+	var convReq models.ConversationRequest
+	err := json.NewDecoder(r.Body).Decode(&convReq)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error Marshalling the request, provide valid ConversationRequest"}`))
+		return
+	}
+	// Reject invalid requests before the stream starts, while the status can still be set.
+	if err := conversation.ValidateRequest(convReq); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	emit := func(event conversation.Event) error {
+		// Stop generating once the client is gone.
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	_, err = conversation.ClassConversationStream(convReq, emit)
+	if err != nil && r.Context().Err() == nil {
+		emit(conversation.Event{Name: "error", Data: map[string]string{"error": "Error calling ClassConversation"}})
+	}
+}
+
 func IntellichunkHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -41,7 +103,19 @@ func IntellichunkHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objIDs, err := intellichunk.Add(intReq.ClassName, intReq.LongText)
+	if _, err := intellichunk.NewChunker(intReq.Strategy, nil, nil); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Unknown chunking strategy, provide llm, token, recursive, markdown or semantic"}`))
+		return
+	}
+	ingestor, err := intellichunk.NewIngestor(intellichunk.WithStrategy(intReq.Strategy), intellichunk.WithNodeMetadata(intReq.Metadata))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error creating the ingestor"}`))
+		return
+	}
+
+	objIDs, err := ingestor.Add(intReq.ClassName, intReq.LongText)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"error": "Error calling ClassConversation"}`))
@@ -50,3 +124,72 @@ func IntellichunkHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(objIDs)
 }
+
+// ListConversationsHandler lists the stored conversations, without their transcripts.
+func ListConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sessions, err := session.NewStore()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error opening the session store"}`))
+		return
+	}
+	infos, err := sessions.List(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error listing the conversations"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(infos)
+}
+
+// GetConversationHandler returns a conversation with its transcript.
+func GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sessions, err := session.NewStore()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error opening the session store"}`))
+		return
+	}
+	sess, err := sessions.Get(r.Context(), mux.Vars(r)["id"])
+	if err == session.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Conversation not found"}`))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error getting the conversation"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sess)
+}
+
+// DeleteConversationHandler deletes a conversation.
+func DeleteConversationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sessions, err := session.NewStore()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error opening the session store"}`))
+		return
+	}
+	err = sessions.Delete(r.Context(), mux.Vars(r)["id"])
+	if err == session.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Conversation not found"}`))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Error deleting the conversation"}`))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
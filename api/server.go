@@ -14,7 +14,11 @@ func Run_api() {
 	const port string = ":8080"
 
 	//router.HandleFunc("/version", Version).Methods("GET")
-	router.HandleFunc("/conversation", ConversationHandler).Methods("GET")
+	router.HandleFunc("/conversation", ConversationHandler).Methods("GET", "POST")
+	router.HandleFunc("/conversation/stream", StreamConversationHandler).Methods("POST")
+	router.HandleFunc("/conversations", ListConversationsHandler).Methods("GET")
+	router.HandleFunc("/conversations/{id}", GetConversationHandler).Methods("GET")
+	router.HandleFunc("/conversations/{id}", DeleteConversationHandler).Methods("DELETE")
 	router.HandleFunc("/intellichunk/add", IntellichunkHandler).Methods("POST")
 
 	log.Println("Server listening on port ", port)
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/cckalen/intellichunk/internal/intellichunk"
 	"github.com/spf13/cobra"
@@ -22,8 +23,10 @@ var addCmd = &cobra.Command{
 
 	path is relative to the project folder.
 	-s flag can be used to save everything into .json files.
+	--strategy selects how the texts are split: llm (default), token, recursive, markdown or semantic.
+	--metadata has the model write the keywords and questions of the nodes split by the other strategies.
 	For example:
-	add "class1" "/files/" -s`,
+	add "class1" "/files/" -s --strategy markdown`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 2 {
 			log.Fatalf("add command requires exactly 2 arguments: [class name] [folder path]")
@@ -34,6 +37,19 @@ var addCmd = &cobra.Command{
 			log.Fatalf("Error retrieving save flag: %v", err)
 		}
 
+		strategy, err := cmd.Flags().GetString("strategy")
+		if err != nil {
+			log.Fatalf("Error retrieving strategy flag: %v", err)
+		}
+		metadata, err := cmd.Flags().GetBool("metadata")
+		if err != nil {
+			log.Fatalf("Error retrieving metadata flag: %v", err)
+		}
+		ingestor, err := intellichunk.NewIngestor(intellichunk.WithStrategy(strategy), intellichunk.WithNodeMetadata(metadata))
+		if err != nil {
+			log.Fatalf("Error creating the ingestor: %v", err)
+		}
+
 		className := args[0]
 		relFolderPath := args[1]
 		// Getting the current working directory (you should run this where your project root is)
@@ -45,7 +61,7 @@ var addCmd = &cobra.Command{
 		// Joining the project root with the relative folder path provided
 		absFolderPath := filepath.Join(projectRoot, relFolderPath)
 
-		_, err = intellichunk.AddFromFolder(className, absFolderPath, save)
+		_, err = ingestor.AddFromFolder(className, absFolderPath, save)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -56,4 +72,6 @@ var addCmd = &cobra.Command{
 func init() {
 	intellichunkCmd.AddCommand(addCmd)
 	addCmd.Flags().BoolP("save", "s", false, "Indicate if you want to save the nodes into a file")
+	addCmd.Flags().String("strategy", intellichunk.StrategyLLM, "Chunking strategy: "+strings.Join(intellichunk.Strategies(), ", "))
+	addCmd.Flags().Bool("metadata", false, "Have the model write the keywords and questions of the nodes split without it")
 }
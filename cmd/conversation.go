@@ -20,7 +20,8 @@ var conversationCmd = &cobra.Command{
 	Run:   runConversation,
 }
 
-var chatHistory []string
+// conversationID is the conversation continued by every query, its history is kept by the session store.
+var conversationID string
 
 func runConversation(cmd *cobra.Command, args []string) {
 	var ClassID, query string
@@ -42,18 +43,28 @@ func runConversation(cmd *cobra.Command, args []string) {
 
 	for {
 		convoReq := models.ConversationRequest{
-			ClassID:     ClassID,
-			Query:       query,
-			ChatHistory: chatHistory,
+			ConversationID: conversationID,
+			ClassID:        ClassID,
+			Query:          query,
 		}
 
-		convoResp, err := conversation.ClassConversation(convoReq)
+		// Print the answer while it is generated.
+		fmt.Print("------  AI: ")
+		convoResp, err := conversation.ClassConversationStream(convoReq, func(event conversation.Event) error {
+			if event.Name == conversation.EventToken {
+				fmt.Print(event.Data)
+			}
+			return nil
+		})
+		fmt.Println()
 		if err != nil {
 			log.Fatalf("Error in conversation: %v", err)
 		}
 
-		chatHistory = append(chatHistory, query, convoResp.Answer.Answer)
-		fmt.Println("------  AI:", convoResp.Answer.Answer)
+		conversationID = convoResp.ConversationID
+		for _, suggestion := range convoResp.Suggestions {
+			fmt.Println("  ? ", suggestion)
+		}
 
 		fmt.Print("\n ::::::  You: ")
 		reader := bufio.NewReader(os.Stdin)
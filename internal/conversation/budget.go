@@ -0,0 +1,174 @@
+package conversation
+
+import (
+	"strings"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/intellichunk"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// TokenCounter counts the tokens of a text as the chat model does, intellichunk.Tokenizer is one.
+type TokenCounter interface {
+	CountTokens(text string) (int, error)
+}
+
+// ContextWindows are the context windows in tokens of known chat models, a model matches the longest prefix of its name.
+var ContextWindows = map[string]int{
+	"gpt-3.5-turbo":     16385,
+	"gpt-3.5-turbo-16k": 16385,
+	"gpt-4":             8192,
+	"gpt-4-32k":         32768,
+	"gpt-4-turbo":       128000,
+	"gpt-4o":            128000,
+	"llama3":            8192,
+	"llama3.1":          128000,
+	"mistral":           32768,
+}
+
+// DefaultContextWindow is the context window assumed for unknown models.
+const DefaultContextWindow = 4096
+
+// DefaultAnswerTokens is the part of the context window kept for the answer.
+const DefaultAnswerTokens = 1024
+
+// _messageTokens is the overhead of every chat message, for its role and delimiters.
+const _messageTokens = 4
+
+// _separator joins the retrieved contents in the prompt.
+const _separator = "\n\n"
+
+// TokenBudget returns the number of tokens the prompt of a chat model may use,
+// its context window minus DefaultAnswerTokens.
+func TokenBudget(modelName string) int {
+	window, prefix := DefaultContextWindow, ""
+	for name, tokens := range ContextWindows {
+		if strings.HasPrefix(modelName, name) && len(name) > len(prefix) {
+			window, prefix = tokens, name
+		}
+	}
+	return window - DefaultAnswerTokens
+}
+
+// WithTokenCounter sets how the prompt tokens are counted, an intellichunk.Tokenizer for the chat model by default.
+func WithTokenCounter(counter TokenCounter) Option {
+	return func(c *Conversation) {
+		c.tokenCounter = counter
+	}
+}
+
+// WithTokenBudget sets the number of tokens the prompt may use, TokenBudget of the chat model by default.
+func WithTokenBudget(tokens int) Option {
+	return func(c *Conversation) {
+		c.tokenBudget = tokens
+	}
+}
+
+// modelName returns the name of the chat model, if it tells.
+func (c *Conversation) modelName() string {
+	if named, ok := c.chatModel.(interface{ ModelName() string }); ok {
+		return named.ModelName()
+	}
+	return ""
+}
+
+// budget returns the token budget of the prompt.
+func (c *Conversation) budget() int {
+	if c.tokenBudget > 0 {
+		return c.tokenBudget
+	}
+	return TokenBudget(c.modelName())
+}
+
+// counter returns the token counter of a prompt, which falls back to an estimate when the tokenizer fails, e.g. offline.
+func (c *Conversation) counter() func(text string) int {
+	counter := c.tokenCounter
+	if counter == nil {
+		counter = intellichunk.NewTokenizer(c.modelName())
+	}
+	failed := false
+	return func(text string) int {
+		if !failed {
+			tokens, err := counter.CountTokens(text)
+			if err == nil {
+				return tokens
+			}
+			// Don't retry a tokenizer that can't load its encoding for every text.
+			log.Warningf("Failed to count tokens, estimating them: %v", err)
+			failed = true
+		}
+		// About 4 characters per token in English.
+		return (len(text) + 3) / 4
+	}
+}
+
+// packedPrompt is what fits into the token budget.
+type packedPrompt struct {
+	results []vectorstore.SearchResult
+	history []string
+	report  models.ContextReport
+}
+
+// pack fits the prompt into the token budget. The question and the system prompt always fit,
+// the oldest history is dropped first, a user message with its answer at a time, then the least relevant contents.
+// The most relevant content is always kept, the report then tells the prompt is over budget.
+func (c *Conversation) pack(system string, results []vectorstore.SearchResult, history []string, query string) packedPrompt {
+	count := c.counter()
+	packed := packedPrompt{report: models.ContextReport{Budget: c.budget()}}
+
+	used := count(system) + _messageTokens + count(query) + _messageTokens
+	contentTokens := make([]int, len(results))
+	for i, result := range results {
+		contentTokens[i] = count(fact(i, result)) + count(_separator)
+		used += contentTokens[i]
+	}
+
+	// Drop the least relevant contents that don't fit even without history.
+	kept := len(results)
+	for kept > 1 && used > packed.report.Budget {
+		kept--
+		used -= contentTokens[kept]
+	}
+	packed.results = results[:kept]
+	for _, result := range results[kept:] {
+		title, _ := result.Properties["title"].(string)
+		packed.report.DroppedSources = append(packed.report.DroppedSources, title)
+	}
+
+	// Keep the newest history that fits, the "Chat History: " header is counted with the first message.
+	historyTokens := make([]int, len(history))
+	total := 0
+	for i, message := range history {
+		historyTokens[i] = count(message) + _messageTokens
+		total += historyTokens[i]
+	}
+	if total > 0 {
+		total += _messageTokens
+	}
+	start := 0
+	for start < len(history) && used+total > packed.report.Budget {
+		// Drop a user message and its answer together so the history keeps alternating.
+		for i := start; i < start+2 && i < len(history); i++ {
+			total -= historyTokens[i]
+		}
+		start += 2
+	}
+	if start >= len(history) {
+		start, total = len(history), 0
+	}
+	packed.history = history[start:]
+	packed.report.DroppedHistory = start
+	packed.report.Tokens = used + total
+
+	if packed.report.Tokens > packed.report.Budget {
+		log.Warningf("The prompt takes %d tokens, over the budget of %d", packed.report.Tokens, packed.report.Budget)
+	}
+	return packed
+}
+
+// content returns the content property of a result.
+func content(result vectorstore.SearchResult) string {
+	text, _ := result.Properties["content"].(string)
+	return text
+}
@@ -0,0 +1,178 @@
+package conversation
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// _citationProperties are the properties of the retrieved nodes cited in the answers.
+var _citationProperties = []string{
+	"reference_title", "reference_url", "section_number",
+	"source_path", "article_index", "start_offset", "end_offset", "start_char", "end_char", "content_hash", "ingested_at",
+}
+
+// _citationMarker matches the citation markers of an answer, such as [2] or [1, 3].
+var _citationMarker = regexp.MustCompile(`\s*\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// fact returns the content of the i-th result as numbered in the prompt, for the model to cite it.
+func fact(i int, result vectorstore.SearchResult) string {
+	return "[" + strconv.Itoa(i+1) + "] " + content(result)
+}
+
+// numberedFacts merges the numbered contents of the results into the facts of the prompt.
+func numberedFacts(results []vectorstore.SearchResult) string {
+	facts := make([]string, 0, len(results))
+	for i, result := range results {
+		facts = append(facts, fact(i, result))
+	}
+	return strings.Join(facts, _separator)
+}
+
+// cite resolves the citation markers of the answer to the results they number.
+// Markers of facts that weren't in the prompt are stripped from the answer. It returns the answer and the citations,
+// in the order they are first cited, each with the sentences of the answer citing it.
+func cite(answer string, results []vectorstore.SearchResult) (string, []models.Citation) {
+	var citations []models.Citation
+	byMarker := make(map[int]int)
+
+	var b strings.Builder
+	last := 0
+	for _, match := range _citationMarker.FindAllStringSubmatchIndex(answer, -1) {
+		start, end := match[0], match[1]
+		quote := citedSentence(answer[:start])
+
+		var valid []string
+		for _, number := range strings.Split(answer[match[2]:match[3]], ",") {
+			marker, err := strconv.Atoi(strings.TrimSpace(number))
+			if err != nil || marker < 1 || marker > len(results) {
+				// Not a source of the answer, the model made it up.
+				continue
+			}
+			valid = append(valid, strconv.Itoa(marker))
+
+			i, ok := byMarker[marker]
+			if !ok {
+				i = len(citations)
+				byMarker[marker] = i
+				citations = append(citations, citation(marker, results[marker-1]))
+			}
+			if quote != "" && !contains(citations[i].Quotes, quote) {
+				citations[i].Quotes = append(citations[i].Quotes, quote)
+			}
+		}
+
+		b.WriteString(answer[last:start])
+		if len(valid) > 0 {
+			// Keep the spacing of the model.
+			b.WriteString(answer[start:end][:strings.Index(answer[start:end], "[")])
+			b.WriteString("[" + strings.Join(valid, ", ") + "]")
+		}
+		last = end
+	}
+	b.WriteString(answer[last:])
+	return b.String(), citations
+}
+
+// citation returns the citation of a result numbered marker in the prompt.
+func citation(marker int, result vectorstore.SearchResult) models.Citation {
+	c := models.Citation{Marker: marker, ID: result.ID}
+	c.Title, _ = result.Properties["title"].(string)
+	c.ReferenceTitle, _ = result.Properties["reference_title"].(string)
+	c.ReferenceURL, _ = result.Properties["reference_url"].(string)
+	if section, ok := toNumber(result.Properties["section_number"]); ok {
+		c.SectionNumber = int(section)
+	}
+
+	c.SourcePath, _ = result.Properties["source_path"].(string)
+	c.ContentHash, _ = result.Properties["content_hash"].(string)
+	// The numbers are only set when the content has them, the null offsets of contents not found in their source stay nil.
+	for property, field := range map[string]**int{
+		"article_index": &c.ArticleIndex,
+		"start_offset":  &c.StartOffset,
+		"end_offset":    &c.EndOffset,
+		"start_char":    &c.StartChar,
+		"end_char":      &c.EndChar,
+	} {
+		if number, ok := toNumber(result.Properties[property]); ok {
+			value := int(number)
+			*field = &value
+		}
+	}
+	c.IngestedAt = toTime(result.Properties["ingested_at"])
+	return c
+}
+
+// toTime returns the time of a property, the stores return the times as RFC 3339 strings. It returns nil for the zero time.
+func toTime(value interface{}) *time.Time {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil
+		}
+		t = parsed
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// citedSentence returns the last sentence of the text before a citation marker, without its markers.
+func citedSentence(text string) string {
+	text = _citationMarker.ReplaceAllString(text, "")
+	text = strings.TrimRight(text, " \t")
+	// The marker may follow the end of the sentence it cites.
+	body := strings.TrimRight(text, ".!?")
+	start := strings.LastIndexAny(body, ".!?\n") + 1
+	return strings.TrimSpace(text[start:])
+}
+
+// citedSources returns the reference URLs of the citations, or their titles when they have none.
+func citedSources(citations []models.Citation) []string {
+	var sources []string
+	for _, c := range citations {
+		source := c.ReferenceURL
+		if source == "" {
+			source = c.ReferenceTitle
+		}
+		if source == "" {
+			source = c.Title
+		}
+		if source != "" && !contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// resultSources returns the reference URLs, or titles, of the results.
+func resultSources(results []vectorstore.SearchResult) []string {
+	return citedSources(retrievedCitations(results))
+}
+
+// retrievedCitations returns the citations of the results, numbered as in the prompt.
+func retrievedCitations(results []vectorstore.SearchResult) []models.Citation {
+	citations := make([]models.Citation, 0, len(results))
+	for i, result := range results {
+		citations = append(citations, citation(i+1, result))
+	}
+	return citations
+}
+
+func contains(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
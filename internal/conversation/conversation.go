@@ -2,67 +2,344 @@ package conversation
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/apsystole/log"
 	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/memory"
 	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/rerank"
+	"github.com/cckalen/intellichunk/internal/session"
 	"github.com/cckalen/intellichunk/internal/templateprompt"
 	"github.com/cckalen/intellichunk/internal/vectorstore"
+	"github.com/google/uuid"
 )
 
+// Conversation answers questions about a class using the content retrieved from a vectorstore.
+// The package level functions use a Conversation built from the configured providers,
+// tests and other callers can inject their own models and store with the options.
+type Conversation struct {
+	chatModel   llm.ChatModel
+	embedder    llm.Embedder
+	store       vectorstore.VectorStore
+	maxDistance float32
+	topK        int
+	reranker    rerank.Reranker
+	rerankerSet bool
+	candidates  int
+	mmrLambda   *float32
+	// tokenCounter and tokenBudget fit the prompt into the context window of the chat model.
+	tokenCounter  TokenCounter
+	tokenBudget   int
+	memory        *memory.Memory
+	memorySet     bool
+	sessions      session.Store
+	sessionTTL    time.Duration
+	sessionTTLSet bool
+	noRewrite     bool
+	suggestions   int
+	// groundedness is keyed by class, AllClasses for the classes without their own.
+	groundedness map[string]Groundedness
+}
+
+// DefaultTopK is the number of retrieved contents put in the prompt.
+const DefaultTopK = 3
+
+// DefaultCandidates is the number of candidates retrieved for the reranker to pick the best DefaultTopK from.
+const DefaultCandidates = 10
+
+// DefaultMaxDistance is the distance beyond which retrieved content isn't considered relevant, for cosine distances.
+const DefaultMaxDistance = 0.8
+
+// NoRelevantSourcesAnswer is the answer when nothing relevant to the question was retrieved.
+const NoRelevantSourcesAnswer = "No relevant sources found."
+
+// Option is a function that can modify the Conversation configuration.
+type Option func(*Conversation)
+
+// WithChatModel sets the model used to answer.
+func WithChatModel(chatModel llm.ChatModel) Option {
+	return func(c *Conversation) {
+		c.chatModel = chatModel
+	}
+}
+
+// WithEmbedder sets the model used to vectorize the questions.
+func WithEmbedder(embedder llm.Embedder) Option {
+	return func(c *Conversation) {
+		c.embedder = embedder
+	}
+}
+
+// WithStore sets the vectorstore the relevant content is retrieved from.
+func WithStore(store vectorstore.VectorStore) Option {
+	return func(c *Conversation) {
+		c.store = store
+	}
+}
+
+// WithMaxDistance sets the distance beyond which retrieved content is dropped, DefaultMaxDistance by default.
+// It is in the metric of the store, e.g. lower it for a dot product store.
+func WithMaxDistance(distance float32) Option {
+	return func(c *Conversation) {
+		c.maxDistance = distance
+	}
+}
+
+// WithTopK sets the number of retrieved contents put in the prompt, DefaultTopK by default.
+func WithTopK(k int) Option {
+	return func(c *Conversation) {
+		c.topK = k
+	}
+}
+
+// WithReranker rescores the retrieved candidates with reranker and keeps the best ones, a nil reranker disables reranking.
+// By default the reranker is selected by the RERANKER environment variable.
+func WithReranker(reranker rerank.Reranker) Option {
+	return func(c *Conversation) {
+		c.reranker = reranker
+		c.rerankerSet = true
+	}
+}
+
+// WithCandidates sets how many candidates are retrieved for the reranker, DefaultCandidates by default.
+func WithCandidates(n int) Option {
+	return func(c *Conversation) {
+		c.candidates = n
+	}
+}
+
+// WithMMR diversifies the retrieved contents with maximal marginal relevance, see vectorstore.MMR.
+// More candidates are retrieved, and lambda trades their relevance (1) for diversity (0).
+func WithMMR(lambda float32) Option {
+	return func(c *Conversation) {
+		c.mmrLambda = &lambda
+	}
+}
+
+// WithMemory summarizes the older chat history with memory, a nil memory sends the whole history.
+// By default it is summarized by the chat model with the memory defaults.
+func WithMemory(m *memory.Memory) Option {
+	return func(c *Conversation) {
+		c.memory = m
+		c.memorySet = true
+	}
+}
+
+// WithSessions sets the store of the conversations, the one selected by SESSION_STORE by default.
+func WithSessions(store session.Store) Option {
+	return func(c *Conversation) {
+		c.sessions = store
+	}
+}
+
+// WithSessionTTL sets how long conversations are kept after their last turn, forever when 0.
+// By default it is read from SESSION_TTL, or session.DefaultTTL.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(c *Conversation) {
+		c.sessionTTL = ttl
+		c.sessionTTLSet = true
+	}
+}
+
+// New creates a Conversation, anything not set with an option comes from the llm and vectorstore registries.
+//
+// Note: To use custom parameters for Weaviate, look into the vectorstore package and pass
+// WithStore(vectorstore.NewWeaviateStore(vectorstore.WithHost("custom-host"))).
+func New(opts ...Option) (*Conversation, error) {
+	c := &Conversation{maxDistance: DefaultMaxDistance, topK: DefaultTopK, candidates: DefaultCandidates, suggestions: DefaultSuggestions}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.topK <= 0 {
+		return nil, fmt.Errorf("invalid top k %d, at least one content must be retrieved", c.topK)
+	}
+
+	var err error
+	if c.chatModel == nil {
+		c.chatModel, err = llm.NewChatModel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.embedder == nil {
+		c.embedder, err = llm.NewEmbedder()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.store == nil {
+		c.store, err = vectorstore.New()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.sessions == nil {
+		c.sessions, err = session.NewStore()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !c.sessionTTLSet {
+		c.sessionTTL, err = session.TTLFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !c.memorySet {
+		c.memory = memory.New(c.chatModel)
+	}
+	if !c.rerankerSet {
+		c.reranker, err = rerank.New()
+		if err != nil {
+			return nil, err
+		}
+	}
+	groundedness, err := GroundednessFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// The options override the environment.
+	for classID, g := range c.groundedness {
+		groundedness[classID] = g
+	}
+	c.groundedness = groundedness
+	return c, nil
+}
+
+// GetRelevantContent retrieves relevant content with a Conversation built from the configured providers.
+func GetRelevantContent(classname string, question string, opts ...vectorstore.SearchOption) (string, []string, error) {
+	c, err := New()
+	if err != nil {
+		return "", nil, err
+	}
+	return c.GetRelevantContent(classname, question, opts...)
+}
+
+// ClassConversation answers a conversation request with a Conversation built from the configured providers.
+func ClassConversation(convoReq models.ConversationRequest) (convoResp models.ConversationResponse, err error) {
+	c, err := New()
+	if err != nil {
+		return convoResp, err
+	}
+	return c.ClassConversation(convoReq)
+}
+
+// Retrieve vectorizes the question and returns the closest objects of the class within the max distance of the Conversation.
+// With a reranker, more candidates are retrieved and the most relevant ones according to the reranker are returned.
+// With MMR, the returned candidates are also picked to cover distinct sources.
+// The results hold the content, title, reference_title, reference_url and section_number properties.
+func (c *Conversation) Retrieve(classname string, question string, opts ...vectorstore.SearchOption) ([]vectorstore.SearchResult, error) {
+	return c.retrieve(classname, question, c.mmrLambda, opts...)
+}
+
+// retrieve is Retrieve with the MMR lambda of a request, nil to not diversify.
+func (c *Conversation) retrieve(classname string, question string, mmrLambda *float32, opts ...vectorstore.SearchOption) ([]vectorstore.SearchResult, error) {
+	graphFieldNames := append([]string{"content", "title"}, _citationProperties...)
+	withLimit := c.topK
+	if (c.reranker != nil || mmrLambda != nil) && c.candidates > withLimit {
+		withLimit = c.candidates
+	}
+	if mmrLambda != nil {
+		opts = append(opts, vectorstore.WithVectors())
+	}
+
+	// Vectorize the question with the configured embedder so retrieval doesn't depend on the store's vectorizer.
+	queryVectors, err := c.embedder.GenerateMultipleEmbeddingsFromText(context.Background(), []string{question})
+	if err != nil {
+		log.Errorf("Failed to vectorize the question: %v", err)
+		return nil, err
+	}
+	if len(queryVectors) == 0 {
+		return nil, errors.New("no embedding returned for the question")
+	}
+
+	// The options of the caller come last so they can override the defaults.
+	opts = append([]vectorstore.SearchOption{
+		vectorstore.WithQueryVector(queryVectors[0]),
+		vectorstore.WithMaxDistance(c.maxDistance),
+	}, opts...)
+	results, err := c.store.SimilaritySearch(classname, question, graphFieldNames, withLimit, opts...)
+	if err != nil {
+		log.Errorf("Failed to perform similarity search: %v", err)
+		return nil, err
+	}
+
+	if c.reranker != nil {
+		reranked, err := c.reranker.Rerank(context.Background(), question, results)
+		if err != nil {
+			// The similarity order is still a sensible answer.
+			log.Warningf("Failed to rerank, keeping the similarity order: %v", err)
+		} else {
+			results = reranked
+		}
+	}
+
+	if mmrLambda != nil {
+		results = vectorstore.MMR(results, c.topK, *mmrLambda)
+	}
+
+	if len(results) > c.topK {
+		results = results[:c.topK]
+	}
+	return results, nil
+}
+
 // GetRelevantContent retrieves relevant content based on a given classname and question.
 // It uses a vectorstore to perform similarity search and returns the relevant objects/documents in the form of content and reference URLs.
 //
 // Parameters:
-// - classname: The classname of the objects to search in Weaviate.
+// - classname: The classname of the objects to search in the vectorstore.
 // - question: The question or concept to search for similarities.
+// - opts: Additional search options, e.g. vectorstore.WithFilter to scope the search.
 //
 // Returns:
 // - content: The merged content of the relevant contents into a string.
 // - refurls: The reference URLs associated with the relevant objects as array.
-//
-// Note: To use custom parameters, look into the vectorstore package or uncomment the 'vectorstore.WithHost("custom-host")' option.
-func GetRelevantContent(classname string, question string) (string, []string, error) {
-	store := vectorstore.NewWeaviateStore(
-	// vectorstore.WithHost("custom-host").
-	// You can add or remove options as needed.
-	)
-
-	className := classname
-	input := question
-	graphFieldNames := []string{"content", "title"}
-	withLimit := 3
+func (c *Conversation) GetRelevantContent(classname string, question string, opts ...vectorstore.SearchOption) (string, []string, error) {
+	results, err := c.Retrieve(classname, question, opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	content, refurls := mergeResults(results)
+	return content, refurls, nil
+}
 
+// mergeResults merges the contents of the results into a string and returns it with their titles.
+func mergeResults(results []vectorstore.SearchResult) (string, []string) {
 	// Define the arrays for contents and refurls.
 	var contents []string
 	var refurls []string
 
-	result, err := store.SimilaritySearch(className, input, graphFieldNames, withLimit)
-	if err != nil {
-		log.Errorf("Failed to perform similarity search: %v", err)
-		return "", refurls, err
-	}
-
-	// slice of map[string]interface{}.
-	for _, object := range result {
-		for key, value := range object {
-			if key == "content" {
-				contents = append(contents, value.(string))
-			} else if key == "title" {
-				refurls = append(refurls, value.(string))
-			}
+	for _, result := range results {
+		if content, ok := result.Properties["content"].(string); ok {
+			contents = append(contents, content)
+		}
+		if title, ok := result.Properties["title"].(string); ok {
+			refurls = append(refurls, title)
 		}
 	}
 
 	// Merge contents.
-	content := strings.Join(contents, "/n/n")
-	return content, refurls, nil
+	return strings.Join(contents, _separator), refurls
 }
 
 // ClassConversation main function dealing with incoming api calls.
-func ClassConversation(convoReq models.ConversationRequest) (convoResp models.ConversationResponse, err error) {
-	var content string
+func (c *Conversation) ClassConversation(convoReq models.ConversationRequest) (models.ConversationResponse, error) {
+	return c.converse(convoReq, nil)
+}
+
+// converse answers the request, sending the events of the answer to emit unless it is nil.
+func (c *Conversation) converse(convoReq models.ConversationRequest, emit func(Event) error) (convoResp models.ConversationResponse, err error) {
+	send := func(event Event) error {
+		if emit == nil {
+			return nil
+		}
+		return emit(event)
+	}
 
 	// Creating a new TemplateRenderer using our prompt.
 	tr, err := templateprompt.NewTemplateRenderer(templateprompt.HelperAgentPrompt)
@@ -70,37 +347,244 @@ func ClassConversation(convoReq models.ConversationRequest) (convoResp models.Co
 		return
 	}
 
+	if err = ValidateRequest(convoReq); err != nil {
+		return
+	}
+	searchOpts := searchOptions(convoReq)
+	mmrLambda := c.mmrLambda
+	if convoReq.Retrieval != nil && convoReq.Retrieval.MMRLambda != nil {
+		mmrLambda = convoReq.Retrieval.MMRLambda
+	}
+
+	// Continue the conversation stored under the ConversationID, or start one.
+	sess, err := c.session(convoReq)
+	if err != nil {
+		return
+	}
+
+	convoResp.ClassID = convoReq.ClassID
+	convoResp.ConversationID = sess.ID
+	convoResp.Query = convoReq.Query
+	convoResp.Summary = sess.Summary
+
+	// Search what a follow up question is about, the answer still follows its original phrasing.
+	searchQuery := c.rewriteQuery(sess, convoReq.Query)
+	if searchQuery != convoReq.Query {
+		convoResp.RewrittenQuery = searchQuery
+	}
+
 	// Get Relevant Content from this Classs vector database.
-	content, convoResp.Answer.Sources, err = GetRelevantContent(convoReq.ClassID, convoReq.Query)
+	results, err := c.retrieve(convoReq.ClassID, searchQuery, mmrLambda, searchOpts...)
 	if err != nil {
 		return
 	}
 
-	// Defining parameters for the prompt.
+	// Answering without sources that support it would only make things up.
+	if !c.grounded(convoReq.ClassID, searchQuery, results) {
+		convoResp.Answer = models.Answer{
+			Type:           models.AnswerInsufficientContext,
+			Answer:         InsufficientContextAnswer,
+			ClosestSources: retrievedCitations(results),
+		}
+		if len(results) == 0 {
+			convoResp.Answer.Answer, convoResp.Answer.NoRelevantSources = NoRelevantSourcesAnswer, true
+			convoResp.Answer.ClosestSources = c.closestSources(convoReq.ClassID, searchQuery, searchOpts)
+		}
+		events := []Event{
+			{Name: EventToken, Data: convoResp.Answer.Answer},
+			{Name: EventCitations, Data: convoResp.Answer},
+		}
+		if len(results) > 0 {
+			events = append([]Event{{Name: EventRetrieval, Data: convoResp.Answer.ClosestSources}}, events...)
+		}
+		for _, event := range events {
+			if err = send(event); err != nil {
+				return
+			}
+		}
+		err = c.saveTurn(sess, convoReq.Query, convoResp.Answer.Answer)
+		return
+	}
+
+	// Summarize the older history so it doesn't grow forever.
+	history := sess.History()
+	if c.memory != nil {
+		summary, recent, summarized, err := c.memory.Condense(context.Background(), sess.Summary, history)
+		if err != nil {
+			// The token budget still trims the history.
+			log.Warningf("Failed to summarize the chat history, sending it whole: %v", err)
+		} else {
+			sess.Summary, sess.Summarized, history = summary, sess.Summarized+summarized, recent
+			convoResp.Summary = summary
+		}
+	}
+
+	// Fit the contents and the history into the context window of the model.
 	params := map[string]string{
 		"Details":   "some dynamic instructive text text",
-		"SSContent": content,
+		"SSContent": "",
+		"Summary":   convoResp.Summary,
 	}
-
-	// Rendering the template with the provided parameters.
 	promptSystem, err := tr.Render(params)
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	packed := c.pack(promptSystem, results, history, convoReq.Query)
+	convoResp.Context = &packed.report
 
-	languageModel := llm.NewOpenAI()
+	// Rendering the template with the contents that fit.
+	params["SSContent"] = numberedFacts(packed.results)
+	promptSystem, err = tr.Render(params)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err = send(Event{Name: EventRetrieval, Data: retrievedCitations(packed.results)}); err != nil {
+		return
+	}
 
 	// Use the language model to generate a chat completion.
-	convoResp.Answer.Answer, err = languageModel.ChatCompletionWithInstructions(context.Background(), promptSystem, convoReq.Query, convoReq.ChatHistory)
+	convoResp.Answer.Type = models.AnswerGrounded
+	convoResp.Answer.Answer, err = c.complete(promptSystem, convoReq.Query, packed.history, packed.results, emit)
 	if err != nil {
 		log.Errorf("Failed to generate chat completion: %v", err)
 		return
 	}
 
-	convoResp.ClassID = convoReq.ClassID
-	convoResp.ConversationID = convoReq.ConversationID
-	convoResp.Query = convoReq.Query
+	// Link the citations of the answer to the contents, dropping the ones the model made up.
+	convoResp.Answer.Answer, convoResp.Answer.Citations = cite(convoResp.Answer.Answer, packed.results)
+	convoResp.Answer.Sources = citedSources(convoResp.Answer.Citations)
+	if len(convoResp.Answer.Citations) == 0 {
+		convoResp.Answer.Sources = resultSources(packed.results)
+	}
+	if err = send(Event{Name: EventCitations, Data: convoResp.Answer}); err != nil {
+		return
+	}
+
+	err = c.saveTurn(sess, convoReq.Query, convoResp.Answer.Answer)
+	if err != nil {
+		return
+	}
 
+	// Suggest questions to continue the conversation.
+	suggestions := c.suggestions
+	if convoReq.SuggestionCount != nil {
+		suggestions = *convoReq.SuggestionCount
+	}
+	convoResp.Suggestions = c.suggest(convoReq.ClassID, sess, packed.results, convoResp.Answer.Answer, suggestions)
+	if len(convoResp.Suggestions) > 0 {
+		err = send(Event{Name: EventSuggestions, Data: convoResp.Suggestions})
+	}
 	return
 }
+
+// session returns the session stored under the ConversationID of the request,
+// or a new one when there is no ConversationID or its session expired.
+func (c *Conversation) session(convoReq models.ConversationRequest) (*session.Session, error) {
+	ttl := c.sessionTTL
+	if convoReq.TTL != nil {
+		ttl = time.Duration(*convoReq.TTL) * time.Second
+	}
+
+	if convoReq.ConversationID != "" {
+		sess, err := c.sessions.Get(context.Background(), convoReq.ConversationID)
+		if err == nil {
+			if sess.ClassID != convoReq.ClassID {
+				return nil, fmt.Errorf("conversation %s is about class %s, not %s", sess.ID, sess.ClassID, convoReq.ClassID)
+			}
+			if convoReq.TTL != nil {
+				sess.SetTTL(ttl)
+			}
+			return sess, nil
+		}
+		if err != session.ErrNotFound {
+			log.Errorf("Failed to load conversation %s: %v", convoReq.ConversationID, err)
+			return nil, err
+		}
+	}
+
+	id := convoReq.ConversationID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return session.New(id, convoReq.ClassID, ttl), nil
+}
+
+// saveTurn adds the question and its answer to the session and stores it.
+func (c *Conversation) saveTurn(sess *session.Session, question, answer string) error {
+	sess.Append(question, answer)
+	if err := c.sessions.Put(context.Background(), sess); err != nil {
+		log.Errorf("Failed to save conversation %s: %v", sess.ID, err)
+		return err
+	}
+	return nil
+}
+
+// ValidateRequest checks the options of a conversation request, so that it can be rejected before it is answered.
+func ValidateRequest(convoReq models.ConversationRequest) error {
+	if convoReq.SuggestionCount != nil && *convoReq.SuggestionCount < 0 {
+		return fmt.Errorf("SuggestionCount must be positive, or 0 for no suggestions, got %d", *convoReq.SuggestionCount)
+	}
+	if convoReq.TTL != nil && *convoReq.TTL < 0 {
+		return fmt.Errorf("TTL must be positive, or 0 to keep the conversation forever, got %d", *convoReq.TTL)
+	}
+	if convoReq.Filter != nil {
+		if err := convoReq.Filter.Validate(); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	retrieval := convoReq.Retrieval
+	if retrieval == nil {
+		return nil
+	}
+	switch retrieval.Mode {
+	case "", models.RetrievalVector:
+	case models.RetrievalHybrid:
+		if retrieval.Alpha != nil && (*retrieval.Alpha < 0 || *retrieval.Alpha > 1) {
+			return fmt.Errorf("retrieval alpha must be between 0 and 1, got %v", *retrieval.Alpha)
+		}
+		switch vectorstore.Fusion(retrieval.Fusion) {
+		case "", vectorstore.AlphaFusion, vectorstore.RRFFusion:
+		default:
+			return fmt.Errorf("unknown retrieval fusion %q", retrieval.Fusion)
+		}
+	default:
+		return fmt.Errorf("unknown retrieval mode %q", retrieval.Mode)
+	}
+	if retrieval.MMRLambda != nil && (*retrieval.MMRLambda < 0 || *retrieval.MMRLambda > 1) {
+		return fmt.Errorf("retrieval mmr_lambda must be between 0 and 1, got %v", *retrieval.MMRLambda)
+	}
+	return nil
+}
+
+// searchOptions returns the search options of a request checked by ValidateRequest.
+func searchOptions(convoReq models.ConversationRequest) []vectorstore.SearchOption {
+	var opts []vectorstore.SearchOption
+
+	// Scope the search to the requested sources, if any.
+	if convoReq.Filter != nil {
+		opts = append(opts, vectorstore.WithFilter(convoReq.Filter))
+	}
+
+	if retrieval := convoReq.Retrieval; retrieval != nil {
+		if retrieval.Mode == models.RetrievalHybrid {
+			alpha := float32(vectorstore.DefaultAlpha)
+			if retrieval.Alpha != nil {
+				alpha = *retrieval.Alpha
+			}
+			fusion := vectorstore.Fusion(retrieval.Fusion)
+			if fusion == "" {
+				fusion = vectorstore.AlphaFusion
+			}
+			opts = append(opts, vectorstore.WithHybrid(alpha, fusion))
+		}
+
+		if retrieval.MaxDistance != nil {
+			opts = append(opts, vectorstore.WithMaxDistance(*retrieval.MaxDistance))
+		}
+	}
+
+	return opts
+}
@@ -1,13 +1,24 @@
 package conversation_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/apsystole/log"
 
 	"github.com/cckalen/intellichunk/config"
 	"github.com/cckalen/intellichunk/internal/conversation"
+	"github.com/cckalen/intellichunk/internal/intellichunk"
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/memory"
 	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/rerank"
+	"github.com/cckalen/intellichunk/internal/session"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
 	"github.com/hlindberg/testutils"
 )
 
@@ -33,7 +44,6 @@ func Test_ClassConversationInvalidID(t *testing.T) {
 	convoReq := models.ConversationRequest{
 		ConversationID: "TestID",
 		ClassID:        "veryWrongClassID",
-		ChatHistory:    []string{""},
 		Query:          "How far is the moon?",
 	}
 
@@ -50,10 +60,16 @@ func Test_ClassConversationInvalidID(t *testing.T) {
 
 // Test_ClassConvoSuccess tests the whole function with chat history
 func Test_ClassConvoSuccess(t *testing.T) {
+	sessions, err := session.NewStore()
+	testutils.CheckNotError(err, t)
+	sess := session.New("TestID", "Class_testRun", time.Hour)
+	sess.Append("What is the location of Ladakh?", "Ladakh is located in the eastern part of the larger Kashmir region and is administered as a union territory by India. It is bordered by the Tibet Autonomous Region to the east and the Indian state of Himachal Pradesh to the south.")
+	sess.Append("Does Tibet have authority over it?", "No, Tibet does not have authority over Ladakh. Ladakh is administered as a union territory by the Government of India. While Ladakh shares a border with the Tibet Autonomous Region, it is governed by the Indian authorities.")
+	testutils.CheckNotError(sessions.Put(context.Background(), sess), t)
+
 	convoReq := models.ConversationRequest{
 		ConversationID: "TestID",
 		ClassID:        "Class_testRun",
-		ChatHistory:    []string{"What is the location of Ladakh?", "Ladakh is located in the eastern part of the larger Kashmir region and is administered as a union territory by India. It is bordered by the Tibet Autonomous Region to the east and the Indian state of Himachal Pradesh to the south.", "Does Tibet have authority over it?", "No, Tibet does not have authority over Ladakh. Ladakh is administered as a union territory by the Government of India. While Ladakh shares a border with the Tibet Autonomous Region, it is governed by the Indian authorities."},
 		Query:          "Can you print out each question and answer as bullet points so far",
 	}
 
@@ -62,3 +78,714 @@ func Test_ClassConvoSuccess(t *testing.T) {
 	log.Println(convoResp)
 	testutils.CheckNotError(err, t)
 }
+
+// ingestOffline adds three Ladakh nodes to a memory store with the fake models.
+func ingestOffline(t *testing.T, fake *llm.Fake) vectorstore.VectorStore {
+	t.Helper()
+	fake.AddFunctionResponses(`{"title": "Ladakh", "summary": "About Ladakh.", "abstract_description": "A region.", "nodes": [
+		{"content": "Ladakh is bordered by the Tibet Autonomous Region to the east.", "keywords": ["Tibet"], "questions": ["What borders Ladakh to the east?"], "sectionNumber": 1},
+		{"content": "Since 1974 the Government of India has encouraged tourism in Ladakh.", "keywords": ["tourism"], "questions": ["When did tourism start?"], "sectionNumber": 2},
+		{"content": "Volcanoes erupt molten rock called lava.", "keywords": ["lava"], "questions": ["What is lava?"], "sectionNumber": 3}]}`)
+	store, err := vectorstore.NewMemoryStore()
+	testutils.CheckNotError(err, t)
+
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithStore(store),
+	)
+	testutils.CheckNotError(err, t)
+
+	objIDs, err := ingestor.Add("Class_offline", "Ladakh is bordered by the Tibet Autonomous Region to the east. Since 1974 the Government of India has encouraged tourism in Ladakh. Volcanoes erupt molten rock called lava.")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(3, len(objIDs), t)
+	return store
+}
+
+// Test_OfflinePipeline runs Add, SimilaritySearch and ClassConversation with the fake llm and an in memory store.
+func Test_OfflinePipeline(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	fake.AddChatResponses("Tourism has been encouraged since 1974.")
+
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+	)
+	testutils.CheckNotError(err, t)
+
+	convoResp, err := convo.ClassConversation(models.ConversationRequest{
+		ConversationID: "TestID",
+		ClassID:        "Class_offline",
+		Query:          "When did the Government of India encourage tourism?",
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Tourism has been encouraged since 1974.", convoResp.Answer.Answer, t)
+	testutils.CheckEqual("TestID", convoResp.ConversationID, t)
+
+	// The most similar node is placed in the system prompt first.
+	calls := fake.Calls()
+	system := calls[len(calls)-1].SystemMessage
+	testutils.CheckTrue(strings.Index(system, "tourism in Ladakh") < strings.Index(system, "Tibet"), t)
+
+	// A filter scopes the retrieval to the matching nodes only.
+	fake.AddChatResponses("Lava is molten rock.")
+	filter := models.Eq("keywords", "lava")
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "What is the molten rock called lava?",
+		Filter:  &filter,
+	})
+	testutils.CheckNotError(err, t)
+	calls = fake.Calls()
+	system = calls[len(calls)-1].SystemMessage
+	testutils.CheckTrue(strings.Contains(system, "lava"), t)
+	testutils.CheckFalse(strings.Contains(system, "Tibet"), t)
+
+	// Hybrid retrieval is selected per request, unknown modes are rejected.
+	fake.AddChatResponses("Lava is molten rock.")
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID:   "Class_offline",
+		Query:     "lava",
+		Retrieval: &models.Retrieval{Mode: models.RetrievalHybrid, Fusion: "rrf"},
+	})
+	testutils.CheckNotError(err, t)
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID:   "Class_offline",
+		Query:     "lava",
+		Retrieval: &models.Retrieval{Mode: "fuzzy"},
+	})
+	testutils.CheckNotNil(err, t)
+
+	// Nothing close enough to the question is found, the model isn't asked to make an answer up.
+	before := len(fake.Calls())
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "Recommend xylophone lessons",
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(models.AnswerInsufficientContext, convoResp.Answer.Type, t)
+	testutils.CheckTrue(convoResp.Answer.NoRelevantSources, t)
+	testutils.CheckEqual(conversation.NoRelevantSourcesAnswer, convoResp.Answer.Answer, t)
+	testutils.CheckEqual(0, len(convoResp.Answer.Sources), t)
+	testutils.CheckEqual(before, len(fake.Calls()), t)
+}
+
+func Test_Rerank(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+
+	// The judge prefers the second candidate, the only one kept.
+	fake.AddFunctionResponses(`{"scores": [{"index": 0, "score": 2}, {"index": 1, "score": 8}, {"index": 2, "score": 1}]}`)
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithReranker(rerank.NewLLMReranker(fake)),
+		conversation.WithTopK(1),
+		conversation.WithMaxDistance(2),
+	)
+	testutils.CheckNotError(err, t)
+
+	vectorOrder, err := store.SimilaritySearch("Class_offline", "", []string{"content"}, 3,
+		vectorstore.WithQueryVector(embed(t, fake, "What borders Ladakh to the east?")))
+	testutils.CheckNotError(err, t)
+
+	results, err := convo.Retrieve("Class_offline", "What borders Ladakh to the east?")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(1, len(results), t)
+	testutils.CheckEqual(vectorOrder[1].ID, results[0].ID, t)
+	testutils.CheckEqual(float32(8), results[0].Score, t)
+
+	// A failing judge keeps the similarity order.
+	results, err = convo.Retrieve("Class_offline", "What borders Ladakh to the east?")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(vectorOrder[0].ID, results[0].ID, t)
+}
+
+func Test_MMR(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithReranker(nil),
+		conversation.WithMaxDistance(2),
+		conversation.WithSuggestions(0),
+	)
+	testutils.CheckNotError(err, t)
+
+	// Lambda 1 keeps the similarity order.
+	question := "What borders Ladakh to the east?"
+	plain, err := convo.Retrieve("Class_offline", question)
+	testutils.CheckNotError(err, t)
+	lambda := float32(1)
+	fake.AddChatResponses("Tibet.")
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID:   "Class_offline",
+		Query:     question,
+		Retrieval: &models.Retrieval{MMRLambda: &lambda},
+	})
+	testutils.CheckNotError(err, t)
+	calls := fake.Calls()
+	system := calls[len(calls)-1].SystemMessage
+	testutils.CheckTrue(strings.Index(system, plain[0].Properties["content"].(string)) < strings.Index(system, plain[1].Properties["content"].(string)), t)
+
+	lambda = 2
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ClassID:   "Class_offline",
+		Query:     question,
+		Retrieval: &models.Retrieval{MMRLambda: &lambda},
+	})
+	testutils.CheckNotNil(err, t)
+
+	_, err = conversation.New(conversation.WithChatModel(fake), conversation.WithEmbedder(fake), conversation.WithStore(store), conversation.WithTopK(-1))
+	testutils.CheckNotNil(err, t)
+}
+
+// words counts a token per word, to test the token budget offline.
+type words struct{}
+
+func (words) CountTokens(text string) (int, error) {
+	return len(strings.Fields(text)), nil
+}
+
+// sessionWith returns a store holding the session "TestID" of Class_offline, with the questions and answers of history.
+func sessionWith(t *testing.T, history ...string) session.Store {
+	t.Helper()
+	sess := session.New("TestID", "Class_offline", 0)
+	for i := 0; i+1 < len(history); i += 2 {
+		sess.Append(history[i], history[i+1])
+	}
+	sessions := session.NewMemoryStore()
+	testutils.CheckNotError(sessions.Put(context.Background(), sess), t)
+	return sessions
+}
+
+func Test_TokenBudget(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	request := models.ConversationRequest{
+		ConversationID: "TestID",
+		ClassID:        "Class_offline",
+		Query:          "When did tourism start in Ladakh?",
+	}
+	converse := func(budget int) (models.ConversationResponse, llm.FakeCall) {
+		convo, err := conversation.New(
+			conversation.WithChatModel(fake),
+			conversation.WithEmbedder(fake),
+			conversation.WithStore(store),
+			conversation.WithSessions(sessionWith(t, "Where is Ladakh?", "In India.", "What borders it to the east?", "Tibet.")),
+			conversation.WithReranker(nil),
+			conversation.WithMaxDistance(2),
+			conversation.WithTokenCounter(words{}),
+			conversation.WithTokenBudget(budget),
+			conversation.WithQueryRewriting(false),
+		)
+		testutils.CheckNotError(err, t)
+		fake.AddChatResponses("In 1974.")
+		convoResp, err := convo.ClassConversation(request)
+		testutils.CheckNotError(err, t)
+		calls := fake.Calls()
+		return convoResp, calls[len(calls)-1]
+	}
+
+	// Everything fits.
+	convoResp, call := converse(1000)
+	testutils.CheckEqual(1000, convoResp.Context.Budget, t)
+	testutils.CheckEqual(0, convoResp.Context.DroppedHistory, t)
+	testutils.CheckEqual(0, len(convoResp.Context.DroppedSources), t)
+	testutils.CheckEqual(4, len(call.ChatHistory), t)
+	testutils.CheckTrue(strings.Contains(call.SystemMessage, "[3] "), t)
+	testutils.CheckTrue(strings.Contains(call.SystemMessage, "east.\n\n"), t)
+	full := convoResp.Context.Tokens
+
+	// The oldest question and its answer go first.
+	convoResp, call = converse(full - 1)
+	testutils.CheckEqual(2, convoResp.Context.DroppedHistory, t)
+	testutils.CheckEqual([]string{"What borders it to the east?", "Tibet."}, call.ChatHistory, t)
+	testutils.CheckTrue(strings.Contains(call.SystemMessage, "[3] "), t)
+	testutils.CheckTrue(convoResp.Context.Tokens <= full-1, t)
+
+	// Then the least relevant contents, keeping the most relevant one.
+	convoResp, call = converse(1)
+	testutils.CheckEqual(4, convoResp.Context.DroppedHistory, t)
+	testutils.CheckEqual(0, len(call.ChatHistory), t)
+	testutils.CheckFalse(strings.Contains(call.SystemMessage, "[2] "), t)
+	testutils.CheckEqual(2, len(convoResp.Context.DroppedSources), t)
+	testutils.CheckTrue(strings.Contains(call.SystemMessage, "tourism"), t)
+}
+
+func Test_TokenBudgetOfModel(t *testing.T) {
+	testutils.CheckEqual(8192-conversation.DefaultAnswerTokens, conversation.TokenBudget("gpt-4-0613"), t)
+	testutils.CheckEqual(32768-conversation.DefaultAnswerTokens, conversation.TokenBudget("gpt-4-32k-0613"), t)
+	testutils.CheckEqual(conversation.DefaultContextWindow-conversation.DefaultAnswerTokens, conversation.TokenBudget("unknown"), t)
+}
+
+func Test_Memory(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	sessions := sessionWith(t, "Where is Ladakh?", "In India.", "What borders it to the east?", "Tibet.")
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(sessions),
+		conversation.WithReranker(nil),
+		conversation.WithMemory(memory.New(fake, memory.WithThreshold(2), memory.WithKeep(2))),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithQueryRewriting(false),
+	)
+	testutils.CheckNotError(err, t)
+	request := models.ConversationRequest{
+		ConversationID: "TestID",
+		ClassID:        "Class_offline",
+		Query:          "When did tourism start in Ladakh?",
+	}
+
+	fake.AddChatResponses("The user asked where Ladakh is, it is in India.", "In 1974.")
+	convoResp, err := convo.ClassConversation(request)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("In 1974.", convoResp.Answer.Answer, t)
+	testutils.CheckEqual("The user asked where Ladakh is, it is in India.", convoResp.Summary, t)
+
+	// The answer is generated with the summary and the recent history only.
+	calls := fake.Calls()
+	answer := calls[len(calls)-1]
+	testutils.CheckTrue(strings.Contains(answer.SystemMessage, "it is in India."), t)
+	testutils.CheckEqual([]string{"What borders it to the east?", "Tibet."}, answer.ChatHistory, t)
+
+	// The next summary extends the previous one with the turns that followed it.
+	fake.AddChatResponses("Ladakh is in India and borders Tibet.", "Since 1974.")
+	convoResp, err = convo.ClassConversation(request)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh is in India and borders Tibet.", convoResp.Summary, t)
+	calls = fake.Calls()
+	summarize := calls[len(calls)-2].UserMessage
+	testutils.CheckTrue(strings.Contains(summarize, "it is in India."), t)
+	testutils.CheckTrue(strings.Contains(summarize, "User: What borders it to the east?"), t)
+	testutils.CheckFalse(strings.Contains(summarize, "Where is Ladakh?"), t)
+
+	// The whole transcript is kept.
+	sess, err := sessions.Get(context.Background(), "TestID")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(8, len(sess.Messages), t)
+	testutils.CheckEqual(4, sess.Summarized, t)
+}
+
+func Test_Sessions(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	sessions := session.NewMemoryStore()
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(sessions),
+		conversation.WithSessionTTL(time.Hour),
+		conversation.WithReranker(nil),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithSuggestions(0),
+	)
+	testutils.CheckNotError(err, t)
+
+	// A conversation without ConversationID gets one, its first question isn't rewritten.
+	fake.AddChatResponses("Tibet.")
+	before := len(fake.Calls())
+	convoResp, err := convo.ClassConversation(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "What borders Ladakh to the east?",
+	})
+	testutils.CheckNotError(err, t)
+	id := convoResp.ConversationID
+	testutils.CheckTrue(id != "", t)
+	testutils.CheckEqual("", convoResp.RewrittenQuery, t)
+	testutils.CheckEqual(before+1, len(fake.Calls()), t)
+
+	// The follow up only sends the query, the history comes from the session.
+	// It is searched as a standalone query, and answered as asked.
+	fake.AddChatResponses(`"When did tourism start in Ladakh?"`, "Since 1974.")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{
+		ConversationID: id,
+		ClassID:        "Class_offline",
+		Query:          "And when did tourism start there?",
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("When did tourism start in Ladakh?", convoResp.RewrittenQuery, t)
+	testutils.CheckEqual("And when did tourism start there?", convoResp.Query, t)
+	calls := fake.Calls()
+	rewrite, answer := calls[len(calls)-2], calls[len(calls)-1]
+	testutils.CheckTrue(strings.Contains(rewrite.UserMessage, "Assistant: Tibet."), t)
+	testutils.CheckTrue(strings.Contains(rewrite.UserMessage, "Follow up question: And when did tourism start there?"), t)
+	testutils.CheckEqual("And when did tourism start there?", answer.UserMessage, t)
+	testutils.CheckEqual([]string{"What borders Ladakh to the east?", "Tibet."}, answer.ChatHistory, t)
+	testutils.CheckTrue(strings.Index(answer.SystemMessage, "tourism in Ladakh") < strings.Index(answer.SystemMessage, "Tibet"), t)
+
+	sess, err := sessions.Get(context.Background(), id)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(4, len(sess.Messages), t)
+	testutils.CheckTrue(sess.ExpiresAt.After(time.Now().Add(59*time.Minute)), t)
+
+	// The TTL of a request replaces the default one, 0 keeps the conversation forever.
+	forever := int64(0)
+	fake.AddChatResponses("Where is Ladakh?", "In India.")
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ConversationID: id,
+		ClassID:        "Class_offline",
+		Query:          "Where is Ladakh?",
+		TTL:            &forever,
+	})
+	testutils.CheckNotError(err, t)
+	sess, err = sessions.Get(context.Background(), id)
+	testutils.CheckNotError(err, t)
+	testutils.CheckTrue(sess.ExpiresAt == nil, t)
+
+	// A conversation stays about its class.
+	_, err = convo.ClassConversation(models.ConversationRequest{
+		ConversationID: id,
+		ClassID:        "Class_other",
+		Query:          "Where is Ladakh?",
+	})
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_Suggestions(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(session.NewMemoryStore()),
+		conversation.WithReranker(nil),
+		conversation.WithMaxDistance(2),
+		conversation.WithTopK(1),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithQueryRewriting(false),
+	)
+	testutils.CheckNotError(err, t)
+
+	// The questions of the retrieved node come first, then the ones of its neighbours, without asking the model.
+	fake.AddChatResponses("Since 1974.")
+	before := len(fake.Calls())
+	convoResp, err := convo.ClassConversation(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "When did the Government of India encourage tourism?",
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(before+1, len(fake.Calls()), t)
+	testutils.CheckEqual(3, len(convoResp.Suggestions), t)
+	testutils.CheckEqual("When did tourism start?", convoResp.Suggestions[0], t)
+
+	// Questions already asked aren't suggested, the model writes the missing ones.
+	count := 3
+	fake.AddChatResponses("Since 1974.", "1. What is lava?\n- Who governs Ladakh?\n\nWhat is the capital of Ladakh?")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{
+		ConversationID:  convoResp.ConversationID,
+		ClassID:         "Class_offline",
+		Query:           "When did tourism start?",
+		SuggestionCount: &count,
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(3, len(convoResp.Suggestions), t)
+	testutils.CheckFalse(strings.Contains(strings.Join(convoResp.Suggestions, "|"), "tourism"), t)
+	testutils.CheckEqual("Who governs Ladakh?", convoResp.Suggestions[2], t)
+
+	none := 0
+	fake.AddChatResponses("Since 1974.")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{
+		ConversationID:  convoResp.ConversationID,
+		ClassID:         "Class_offline",
+		Query:           "When did tourism start in Ladakh?",
+		SuggestionCount: &none,
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(0, len(convoResp.Suggestions), t)
+}
+
+func Test_Citations(t *testing.T) {
+	fake := llm.NewFake()
+	store, err := vectorstore.NewMemoryStore()
+	testutils.CheckNotError(err, t)
+	tourism := "Since 1974 the Government of India has encouraged tourism in Ladakh."
+	border := "Ladakh is bordered by the Tibet Autonomous Region to the east."
+	ingestedAt := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	start, end, startChar, endChar := 0, 70, 0, 68
+	_, err = store.AddNodeObjects("Class_cited", []models.ContainerNodeVector{
+		{Title: "Ladakh", Content: tourism, NodeNumber: 4, RefTitle: "Ladakh tourism", ReferenceURL: "https://example.com/tourism",
+			IngestedAt: ingestedAt, SourcePath: "/files/ladakh.txt", ArticleIndex: 2, StartOffset: &start, EndOffset: &end, StartChar: &startChar, EndChar: &endChar,
+			ContentHash: "c0ffee", Embedding: embed(t, fake, tourism)},
+		{Title: "Ladakh", Content: border, NodeNumber: 1, RefTitle: "Ladakh", ReferenceURL: "https://example.com/ladakh", Embedding: embed(t, fake, border)},
+	})
+	testutils.CheckNotError(err, t)
+
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(session.NewMemoryStore()),
+		conversation.WithReranker(nil),
+		conversation.WithMaxDistance(2),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithSuggestions(0),
+	)
+	testutils.CheckNotError(err, t)
+
+	// The facts are numbered in the prompt in the retrieval order.
+	question := "When did tourism start in Ladakh, which borders Tibet?"
+	results, err := convo.Retrieve("Class_cited", question)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(2, len(results), t)
+	tourismMarker, borderMarker := 1, 2
+	if results[0].Properties["content"] != tourism {
+		tourismMarker, borderMarker = 2, 1
+	}
+
+	// Made up markers are stripped.
+	fake.AddChatResponses(fmt.Sprintf("Tourism started in 1974 [%d]. Ladakh borders Tibet. [%d, 7] Also Nepal [9].", tourismMarker, borderMarker))
+	convoResp, err := convo.ClassConversation(models.ConversationRequest{ClassID: "Class_cited", Query: question})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(fmt.Sprintf("Tourism started in 1974 [%d]. Ladakh borders Tibet. [%d] Also Nepal.", tourismMarker, borderMarker), convoResp.Answer.Answer, t)
+	calls := fake.Calls()
+	testutils.CheckTrue(strings.Contains(calls[len(calls)-1].SystemMessage, fmt.Sprintf("[%d] %s", tourismMarker, tourism)), t)
+
+	citations := convoResp.Answer.Citations
+	testutils.CheckEqual(2, len(citations), t)
+	testutils.CheckEqual(tourismMarker, citations[0].Marker, t)
+	testutils.CheckEqual(results[tourismMarker-1].ID, citations[0].ID, t)
+	testutils.CheckEqual("Ladakh tourism", citations[0].ReferenceTitle, t)
+	testutils.CheckEqual("https://example.com/tourism", citations[0].ReferenceURL, t)
+	testutils.CheckEqual(4, citations[0].SectionNumber, t)
+	// The provenance of the content locates the cited passage in its file.
+	testutils.CheckEqual("/files/ladakh.txt", citations[0].SourcePath, t)
+	testutils.CheckEqual(2, *citations[0].ArticleIndex, t)
+	// A passage at the start of its file keeps its offsets of 0.
+	testutils.CheckEqual(0, *citations[0].StartOffset, t)
+	testutils.CheckEqual(70, *citations[0].EndOffset, t)
+	testutils.CheckEqual(0, *citations[0].StartChar, t)
+	testutils.CheckEqual(68, *citations[0].EndChar, t)
+	testutils.CheckEqual("c0ffee", citations[0].ContentHash, t)
+	testutils.CheckTrue(citations[0].IngestedAt != nil && citations[0].IngestedAt.Equal(ingestedAt), t)
+	testutils.CheckEqual([]string{"Tourism started in 1974"}, citations[0].Quotes, t)
+	testutils.CheckEqual([]string{"Ladakh borders Tibet."}, citations[1].Quotes, t)
+	testutils.CheckEqual([]string{"https://example.com/tourism", "https://example.com/ladakh"}, convoResp.Answer.Sources, t)
+
+	// Answers without citations list every source given to the model.
+	fake.AddChatResponses("Tourism started in 1974.")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{ClassID: "Class_cited", Query: question})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(0, len(convoResp.Answer.Citations), t)
+	testutils.CheckEqual(2, len(convoResp.Answer.Sources), t)
+}
+
+func Test_Stream(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	sessions := session.NewMemoryStore()
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(sessions),
+		conversation.WithReranker(nil),
+		conversation.WithMaxDistance(2),
+		conversation.WithTopK(1),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithQueryRewriting(false),
+	)
+	testutils.CheckNotError(err, t)
+
+	// The retrieved sources come first, then the tokens of the answer, its citations, the suggestions and the response.
+	fake.AddChatResponses("Since 1974 [1]. Not [4].")
+	var names []string
+	var tokens string
+	convoResp, err := convo.ClassConversationStream(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "When did the Government of India encourage tourism?",
+	}, func(event conversation.Event) error {
+		if len(names) == 0 || names[len(names)-1] != event.Name {
+			names = append(names, event.Name)
+		}
+		switch event.Name {
+		case conversation.EventRetrieval:
+			testutils.CheckEqual(1, len(event.Data.([]models.Citation)), t)
+		case conversation.EventToken:
+			tokens += event.Data.(string)
+		case conversation.EventCitations:
+			testutils.CheckEqual("Since 1974 [1]. Not.", event.Data.(models.Answer).Answer, t)
+		case conversation.EventDone:
+			testutils.CheckEqual(3, len(event.Data.(models.ConversationResponse).Suggestions), t)
+		}
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual([]string{
+		conversation.EventRetrieval, conversation.EventToken, conversation.EventCitations, conversation.EventSuggestions, conversation.EventDone,
+	}, names, t)
+	// The made up marker is stripped from the tokens too.
+	testutils.CheckEqual("Since 1974 [1]. Not.", tokens, t)
+	testutils.CheckEqual("Since 1974 [1]. Not.", convoResp.Answer.Answer, t)
+
+	// A client going away stops the answer before the turn is saved.
+	gone := errors.New("client gone")
+	fake.AddChatResponses("Since 1974.")
+	_, err = convo.ClassConversationStream(models.ConversationRequest{
+		ClassID:        "Class_offline",
+		ConversationID: convoResp.ConversationID,
+		Query:          "When did tourism start?",
+	}, func(event conversation.Event) error {
+		if event.Name == conversation.EventToken {
+			return gone
+		}
+		return nil
+	})
+	testutils.CheckEqual(gone, err, t)
+	sess, err := sessions.Get(context.Background(), convoResp.ConversationID)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(2, len(sess.Messages), t)
+
+	// A marker streamed in several tokens is held back until it is complete.
+	fake.AddChatResponses("Since 1974 [1, 4].")
+	tokens = ""
+	_, err = convo.ClassConversationStream(models.ConversationRequest{
+		ClassID: "Class_offline",
+		Query:   "When did tourism start?",
+	}, func(event conversation.Event) error {
+		if event.Name == conversation.EventToken {
+			tokens += event.Data.(string)
+		}
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Since 1974 [1].", tokens, t)
+}
+
+func Test_Groundedness(t *testing.T) {
+	fake := llm.NewFake()
+	store := ingestOffline(t, fake)
+	t.Setenv(conversation.GroundednessEnv, `{"Class_offline": {"min_score": 0.99}}`)
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(session.NewMemoryStore()),
+		conversation.WithReranker(nil),
+		conversation.WithTopK(1),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithSuggestions(0),
+		conversation.WithQueryRewriting(false),
+	)
+	testutils.CheckNotError(err, t)
+	question := "When did the Government of India encourage tourism?"
+
+	// Weak matches aren't answered, the closest sources are listed instead.
+	before := len(fake.Calls())
+	convoResp, err := convo.ClassConversation(models.ConversationRequest{ClassID: "Class_offline", Query: question})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(models.AnswerInsufficientContext, convoResp.Answer.Type, t)
+	testutils.CheckEqual(conversation.InsufficientContextAnswer, convoResp.Answer.Answer, t)
+	// Contents were retrieved, only too weak to answer.
+	testutils.CheckFalse(convoResp.Answer.NoRelevantSources, t)
+	testutils.CheckEqual(1, len(convoResp.Answer.ClosestSources), t)
+	testutils.CheckEqual(before, len(fake.Calls()), t)
+
+	// Nothing within the max distance still lists the closest sources.
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{ClassID: "Class_offline", Query: "Recommend xylophone lessons"})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(conversation.NoRelevantSourcesAnswer, convoResp.Answer.Answer, t)
+	testutils.CheckEqual(1, len(convoResp.Answer.ClosestSources), t)
+	testutils.CheckEqual("Ladakh", convoResp.Answer.ClosestSources[0].Title, t)
+
+	// Without anything retrieved, the stream has no retrieval event.
+	var names []string
+	_, err = convo.ClassConversationStream(models.ConversationRequest{ClassID: "Class_offline", Query: "Recommend xylophone lessons"}, func(event conversation.Event) error {
+		names = append(names, event.Name)
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual([]string{conversation.EventToken, conversation.EventCitations, conversation.EventDone}, names, t)
+
+	// The options override the environment, the model verifies the sources hold the answer.
+	convo, err = conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(session.NewMemoryStore()),
+		conversation.WithReranker(nil),
+		conversation.WithTopK(1),
+		conversation.WithTokenCounter(words{}),
+		conversation.WithSuggestions(0),
+		conversation.WithQueryRewriting(false),
+		conversation.WithGroundedness("Class_offline", conversation.Groundedness{Verify: true}),
+	)
+	testutils.CheckNotError(err, t)
+	fake.AddChatResponses("NO")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{ClassID: "Class_offline", Query: question})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(models.AnswerInsufficientContext, convoResp.Answer.Type, t)
+	calls := fake.Calls()
+	testutils.CheckTrue(strings.Contains(calls[len(calls)-1].UserMessage, "[1] Since 1974"), t)
+
+	fake.AddChatResponses("YES", "Since 1974 [1].")
+	convoResp, err = convo.ClassConversation(models.ConversationRequest{ClassID: "Class_offline", Query: question})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(models.AnswerGrounded, convoResp.Answer.Type, t)
+	testutils.CheckEqual("Since 1974 [1].", convoResp.Answer.Answer, t)
+
+	t.Setenv(conversation.GroundednessEnv, `{"Class_offline": 1}`)
+	_, err = conversation.GroundednessFromEnv()
+	testutils.CheckNotNil(err, t)
+}
+
+// Test_ValidateRequest tests the request options are checked before the conversation is answered.
+func Test_ValidateRequest(t *testing.T) {
+	negative, tooHigh := -1, float32(1.5)
+	ttl := int64(-1)
+	filter := models.Eq("reference_url", "https://en.wikipedia.org/wiki/Ladakh")
+	valid := models.ConversationRequest{
+		ClassID:   "Class_offline",
+		Query:     "What is Ladakh?",
+		Filter:    &filter,
+		Retrieval: &models.Retrieval{Mode: models.RetrievalHybrid, Fusion: "rrf"},
+	}
+	testutils.CheckNotError(conversation.ValidateRequest(valid), t)
+
+	for name, convoReq := range map[string]models.ConversationRequest{
+		"filter":          {Filter: &models.Filter{Operator: "like", Property: "title"}},
+		"mode":            {Retrieval: &models.Retrieval{Mode: "keyword"}},
+		"alpha":           {Retrieval: &models.Retrieval{Mode: models.RetrievalHybrid, Alpha: &tooHigh}},
+		"fusion":          {Retrieval: &models.Retrieval{Mode: models.RetrievalHybrid, Fusion: "max"}},
+		"mmr_lambda":      {Retrieval: &models.Retrieval{MMRLambda: &tooHigh}},
+		"SuggestionCount": {SuggestionCount: &negative},
+		"TTL":             {TTL: &ttl},
+	} {
+		err := conversation.ValidateRequest(convoReq)
+		testutils.CheckNotNil(err, t)
+		testutils.CheckTrue(strings.Contains(err.Error(), name), t)
+	}
+
+	// Invalid requests are rejected before anything is retrieved or generated.
+	fake := llm.NewFake()
+	store, err := vectorstore.NewMemoryStore()
+	testutils.CheckNotError(err, t)
+	convo, err := conversation.New(
+		conversation.WithChatModel(fake),
+		conversation.WithEmbedder(fake),
+		conversation.WithStore(store),
+		conversation.WithSessions(session.NewMemoryStore()),
+	)
+	testutils.CheckNotError(err, t)
+	valid.Retrieval = &models.Retrieval{MMRLambda: &tooHigh}
+	_, err = convo.ClassConversation(valid)
+	testutils.CheckNotNil(err, t)
+	testutils.CheckEqual(0, len(fake.Calls()), t)
+}
+
+func embed(t *testing.T, embedder llm.Embedder, text string) []float32 {
+	t.Helper()
+	vectors, err := embedder.GenerateMultipleEmbeddingsFromText(context.Background(), []string{text})
+	testutils.CheckNotError(err, t)
+	return vectors[0]
+}
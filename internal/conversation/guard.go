@@ -0,0 +1,135 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/templateprompt"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// GroundednessEnv is the environment variable holding the Groundedness of the classes as a JSON object keyed by class,
+// e.g. {"*": {"min_score": 0.3}, "Class_docs": {"min_score": 0.5, "verify": true}}.
+const GroundednessEnv = "GROUNDEDNESS"
+
+// AllClasses is the class of the Groundedness applying to the classes without their own.
+const AllClasses = "*"
+
+// InsufficientContextAnswer is the answer when the retrieved contents don't support answering the question.
+const InsufficientContextAnswer = "The sources don't hold enough to answer this question."
+
+// Groundedness decides whether the contents retrieved for a question are enough to answer it.
+// Without them the model would answer from its general knowledge.
+type Groundedness struct {
+	// MinScore is the Score the best retrieved content must reach: its similarity to the question,
+	// or its relevance when a reranker is set. 0 only requires contents within the max distance.
+	MinScore float32 `json:"min_score"`
+	// Verify asks the chat model whether the retrieved contents answer the question before answering it.
+	Verify bool `json:"verify"`
+}
+
+// WithGroundedness sets the Groundedness of the class, or of every class without its own with AllClasses.
+// It defaults to the one of GroundednessEnv.
+func WithGroundedness(classID string, g Groundedness) Option {
+	return func(c *Conversation) {
+		if c.groundedness == nil {
+			c.groundedness = make(map[string]Groundedness)
+		}
+		c.groundedness[classID] = g
+	}
+}
+
+// GroundednessFromEnv returns the Groundedness of the classes set in GroundednessEnv.
+func GroundednessFromEnv() (map[string]Groundedness, error) {
+	groundedness := make(map[string]Groundedness)
+	value := strings.TrimSpace(os.Getenv(GroundednessEnv))
+	if value == "" {
+		return groundedness, nil
+	}
+	if err := json.Unmarshal([]byte(value), &groundedness); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", GroundednessEnv, err)
+	}
+	return groundedness, nil
+}
+
+// classGroundedness returns the Groundedness of the class.
+func (c *Conversation) classGroundedness(classID string) Groundedness {
+	if g, ok := c.groundedness[classID]; ok {
+		return g
+	}
+	return c.groundedness[AllClasses]
+}
+
+// grounded tells whether the results support answering the question, according to the Groundedness of the class.
+func (c *Conversation) grounded(classID, question string, results []vectorstore.SearchResult) bool {
+	if len(results) == 0 {
+		return false
+	}
+	g := c.classGroundedness(classID)
+
+	best := results[0].Score
+	for _, result := range results[1:] {
+		best = float32(math.Max(float64(best), float64(result.Score)))
+	}
+	if best < g.MinScore {
+		log.Println("Best retrieved score", best, "is below", g.MinScore, "for class", classID)
+		return false
+	}
+
+	if !g.Verify {
+		return true
+	}
+	answerable, err := c.answerable(question, results)
+	if err != nil {
+		// The retrieved contents passed the score threshold already.
+		log.Warningf("Failed to verify the sources support an answer, answering anyway: %v", err)
+		return true
+	}
+	return answerable
+}
+
+// answerable asks the chat model whether the results hold the answer to the question.
+func (c *Conversation) answerable(question string, results []vectorstore.SearchResult) (bool, error) {
+	tr, err := templateprompt.NewTemplateRenderer(templateprompt.VerifyAnswerablePrompt)
+	if err != nil {
+		return false, err
+	}
+	prompt, err := tr.Render(map[string]string{
+		"Facts":    numberedFacts(results),
+		"Question": question,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.chatModel.ChatCompletion(context.Background(), prompt)
+	if err != nil {
+		return false, err
+	}
+	verdict := strings.ToUpper(strings.TrimSpace(resp))
+	switch {
+	case strings.HasPrefix(verdict, "YES"):
+		return true, nil
+	case strings.HasPrefix(verdict, "NO"):
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected verification answer %q", resp)
+}
+
+// closestSources returns the citations of the contents closest to the question, however far they are.
+// They show what the class holds when nothing close enough was retrieved.
+func (c *Conversation) closestSources(classID, question string, opts []vectorstore.SearchOption) []models.Citation {
+	opts = append(opts, vectorstore.WithMaxDistance(math.MaxFloat32))
+	results, err := c.retrieve(classID, question, nil, opts...)
+	if err != nil {
+		log.Warningf("Failed to search the closest sources: %v", err)
+		return nil
+	}
+	return retrievedCitations(results)
+}
@@ -0,0 +1,61 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/memory"
+	"github.com/cckalen/intellichunk/internal/session"
+	"github.com/cckalen/intellichunk/internal/templateprompt"
+)
+
+// _rewriteHistory is the number of most recent history messages the follow up questions are rewritten with.
+const _rewriteHistory = 6
+
+// WithQueryRewriting sets whether follow up questions are rewritten into standalone search queries before retrieval,
+// which it is by default.
+func WithQueryRewriting(enabled bool) Option {
+	return func(c *Conversation) {
+		c.noRewrite = !enabled
+	}
+}
+
+// rewriteQuery condenses a follow up question and the conversation so far into a standalone search query,
+// so questions like "explain #3 a bit" retrieve what #3 is about. Questions starting a conversation are returned as they are,
+// and so is the question when the model fails.
+func (c *Conversation) rewriteQuery(sess *session.Session, question string) string {
+	history := sess.History()
+	if c.noRewrite || len(history) == 0 {
+		return question
+	}
+	if len(history) > _rewriteHistory {
+		history = history[len(history)-_rewriteHistory:]
+	}
+
+	tr, err := templateprompt.NewTemplateRenderer(templateprompt.CondenseQuestionPrompt)
+	if err != nil {
+		log.Warningf("Failed to rewrite the question: %v", err)
+		return question
+	}
+	prompt, err := tr.Render(map[string]string{
+		"Summary":  sess.Summary,
+		"Messages": memory.Transcript(history),
+		"Question": question,
+	})
+	if err != nil {
+		log.Warningf("Failed to rewrite the question: %v", err)
+		return question
+	}
+
+	rewritten, err := c.chatModel.ChatCompletion(context.Background(), prompt)
+	if err != nil {
+		log.Warningf("Failed to rewrite the question, searching it as asked: %v", err)
+		return question
+	}
+	rewritten = strings.Trim(strings.TrimSpace(rewritten), `"`)
+	if rewritten == "" {
+		return question
+	}
+	return rewritten
+}
@@ -0,0 +1,115 @@
+package conversation
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// Names of the events sent while an answer is streamed, in the order they are sent.
+const (
+	// EventRetrieval carries the citations of the retrieved contents put in the prompt, numbered as the model cites them.
+	// It is left out when nothing was retrieved, questions without sources carry their closest sources instead.
+	EventRetrieval = "retrieval"
+	// EventToken carries a piece of the answer as the model generates it. The citation markers are held back until
+	// they are complete, and the made up ones are stripped, so the tokens add up to the answer of EventCitations.
+	EventToken = "token"
+	// EventCitations carries the answer with its made up citation markers stripped, its citations and its sources.
+	EventCitations = "citations"
+	// EventSuggestions carries the follow up questions.
+	EventSuggestions = "suggestions"
+	// EventDone carries the whole response.
+	EventDone = "done"
+)
+
+// Event is sent while an answer is streamed.
+type Event struct {
+	Name string
+	Data interface{}
+}
+
+// ClassConversationStream answers a conversation request with a Conversation built from the configured providers,
+// sending the events of the answer to emit.
+func ClassConversationStream(convoReq models.ConversationRequest, emit func(Event) error) (models.ConversationResponse, error) {
+	c, err := New()
+	if err != nil {
+		return models.ConversationResponse{}, err
+	}
+	return c.ClassConversationStream(convoReq, emit)
+}
+
+// ClassConversationStream answers like ClassConversation, sending the events of the answer to emit while it is generated.
+// Chat models that can't stream send the answer in a single token event. An error returned by emit, such as
+// the client going away, stops the answer without saving the turn.
+func (c *Conversation) ClassConversationStream(convoReq models.ConversationRequest, emit func(Event) error) (models.ConversationResponse, error) {
+	convoResp, err := c.converse(convoReq, emit)
+	if err != nil {
+		return convoResp, err
+	}
+	return convoResp, emit(Event{Name: EventDone, Data: convoResp})
+}
+
+// complete generates the answer citing the results, streaming its tokens to emit when there is one.
+func (c *Conversation) complete(system, query string, history []string, results []vectorstore.SearchResult, emit func(Event) error) (string, error) {
+	if emit == nil {
+		return c.chatModel.ChatCompletionWithInstructions(context.Background(), system, query, history)
+	}
+	tokens := &markerFilter{results: results, emit: emit}
+	if streamer, ok := c.chatModel.(llm.StreamingChatModel); ok {
+		answer, err := streamer.ChatCompletionStream(context.Background(), system, query, history, tokens.write)
+		if err != nil {
+			return answer, err
+		}
+		return answer, tokens.flush()
+	}
+	answer, err := c.chatModel.ChatCompletionWithInstructions(context.Background(), system, query, history)
+	if err != nil {
+		return "", err
+	}
+	if err := tokens.write(answer); err != nil {
+		return answer, err
+	}
+	return answer, tokens.flush()
+}
+
+// _partialMarker matches the start of a citation marker still being streamed, such as [2 or [1,.
+var _partialMarker = regexp.MustCompile(`^\[[\d,\s]*$`)
+
+// markerFilter sends the tokens of an answer with the citation markers of facts that weren't in the prompt stripped,
+// as cite strips them. The end of the answer that may still become a marker, its spaces included, is held back.
+type markerFilter struct {
+	results []vectorstore.SearchResult
+	emit    func(Event) error
+	pending string
+}
+
+// write sends the answer generated so far, up to the marker that may be starting.
+func (f *markerFilter) write(delta string) error {
+	f.pending += delta
+	held := len(strings.TrimRightFunc(f.pending, unicode.IsSpace))
+	if open := strings.LastIndex(f.pending, "["); open >= 0 && _partialMarker.MatchString(f.pending[open:]) {
+		held = len(strings.TrimRightFunc(f.pending[:open], unicode.IsSpace))
+	}
+	text := f.pending[:held]
+	f.pending = f.pending[held:]
+	return f.send(text)
+}
+
+// flush sends the end of the answer held back.
+func (f *markerFilter) flush() error {
+	text := f.pending
+	f.pending = ""
+	return f.send(text)
+}
+
+func (f *markerFilter) send(text string) error {
+	if text, _ = cite(text, f.results); text == "" {
+		return nil
+	}
+	return f.emit(Event{Name: EventToken, Data: text})
+}
@@ -0,0 +1,201 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/session"
+	"github.com/cckalen/intellichunk/internal/templateprompt"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// DefaultSuggestions is the number of follow up questions suggested with every answer.
+const DefaultSuggestions = 3
+
+// WithSuggestions sets the number of follow up questions suggested with every answer, DefaultSuggestions by default.
+// 0 disables the suggestions.
+func WithSuggestions(n int) Option {
+	return func(c *Conversation) {
+		c.suggestions = n
+	}
+}
+
+// suggest returns count follow up questions. They are preferably the questions generated at ingest for the retrieved nodes,
+// then for the nodes next to them in their articles, so they are answered by the class. The model writes the missing ones.
+func (c *Conversation) suggest(classname string, sess *session.Session, results []vectorstore.SearchResult, answer string, count int) []string {
+	if count <= 0 || len(results) == 0 {
+		return nil
+	}
+
+	// Don't suggest what was already asked.
+	seen := make(map[string]bool)
+	var asked []string
+	for _, message := range sess.Messages {
+		if message.Role == session.RoleUser {
+			seen[normalizeQuestion(message.Content)] = true
+			asked = append(asked, message.Content)
+		}
+	}
+
+	var suggestions []string
+	for _, question := range c.storedQuestions(classname, results, answer) {
+		if len(suggestions) == count {
+			return suggestions
+		}
+		if key := normalizeQuestion(question); !seen[key] {
+			seen[key] = true
+			suggestions = append(suggestions, question)
+		}
+	}
+
+	generated, err := c.generateQuestions(results, asked, answer, count-len(suggestions))
+	if err != nil {
+		log.Warningf("Failed to generate suggestions: %v", err)
+		return suggestions
+	}
+	for _, question := range generated {
+		if len(suggestions) == count {
+			break
+		}
+		if key := normalizeQuestion(question); !seen[key] {
+			seen[key] = true
+			suggestions = append(suggestions, question)
+		}
+	}
+	return suggestions
+}
+
+// storedQuestions returns the questions of the retrieved nodes, then of the other nodes of their articles,
+// closest sections first. Articles are told apart by their title.
+func (c *Conversation) storedQuestions(classname string, results []vectorstore.SearchResult, answer string) []string {
+	rank := make(map[string]int)
+	var titles []interface{}
+	seenTitles := make(map[string]bool)
+	for i, result := range results {
+		rank[result.ID] = i
+		if title, ok := result.Properties["title"].(string); ok && !seenTitles[title] {
+			seenTitles[title] = true
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == 0 {
+		return nil
+	}
+
+	// The nodes of the articles closest to the answer, so the suggestions follow it.
+	vectors, err := c.embedder.GenerateMultipleEmbeddingsFromText(context.Background(), []string{answer})
+	if err != nil || len(vectors) == 0 {
+		log.Warningf("Failed to vectorize the answer for suggestions: %v", err)
+		return nil
+	}
+	articles := models.In("title", titles...)
+	nodes, err := c.store.SimilaritySearch(classname, answer, []string{"title", "section_number", "questions"}, len(results)*4,
+		vectorstore.WithQueryVector(vectors[0]),
+		vectorstore.WithFilter(&articles),
+	)
+	if err != nil {
+		log.Warningf("Failed to search the questions of the retrieved articles: %v", err)
+		return nil
+	}
+
+	// Sections of the retrieved nodes by article, to find their neighbours.
+	sections := make(map[string][]float64)
+	for _, node := range nodes {
+		if _, retrieved := rank[node.ID]; retrieved {
+			if section, ok := toNumber(node.Properties["section_number"]); ok {
+				title, _ := node.Properties["title"].(string)
+				sections[title] = append(sections[title], section)
+			}
+		}
+	}
+	// The retrieved nodes come first in their order, then the others by their distance in sections to a retrieved node.
+	gaps := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		if i, retrieved := rank[node.ID]; retrieved {
+			gaps[node.ID] = float64(i - len(results))
+			continue
+		}
+		// Somewhere else in the article by default.
+		gaps[node.ID] = math.MaxFloat64
+		title, _ := node.Properties["title"].(string)
+		if section, ok := toNumber(node.Properties["section_number"]); ok {
+			for _, retrieved := range sections[title] {
+				gaps[node.ID] = math.Min(gaps[node.ID], math.Abs(section-retrieved))
+			}
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return gaps[nodes[i].ID] < gaps[nodes[j].ID] })
+
+	var questions []string
+	for _, node := range nodes {
+		if list, ok := node.Properties["questions"].([]interface{}); ok {
+			for _, question := range list {
+				if q, ok := question.(string); ok && strings.TrimSpace(q) != "" {
+					questions = append(questions, strings.TrimSpace(q))
+				}
+			}
+		}
+	}
+	return questions
+}
+
+// generateQuestions asks the chat model for count follow up questions about the contents of the results.
+func (c *Conversation) generateQuestions(results []vectorstore.SearchResult, asked []string, answer string, count int) ([]string, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+
+	tr, err := templateprompt.NewTemplateRenderer(templateprompt.SuggestQuestionsPrompt)
+	if err != nil {
+		return nil, err
+	}
+	facts, _ := mergeResults(results)
+	prompt, err := tr.Render(map[string]string{
+		"Count":  fmt.Sprint(count),
+		"Facts":  facts,
+		"Asked":  strings.Join(asked, "\n") + "\n",
+		"Answer": answer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.chatModel.ChatCompletion(context.Background(), prompt)
+	if err != nil {
+		return nil, err
+	}
+	var questions []string
+	for _, line := range strings.Split(resp, "\n") {
+		// Models number or bullet their lists anyway.
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*•0123456789.) "))
+		if line != "" {
+			questions = append(questions, line)
+		}
+	}
+	return questions, nil
+}
+
+// normalizeQuestion returns the question lower cased without surrounding spaces and punctuation, to compare questions.
+func normalizeQuestion(question string) string {
+	return strings.ToLower(strings.Trim(question, " \t\n?!."))
+}
+
+// toNumber returns the number of a property, as decoded from JSON or returned by the stores.
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
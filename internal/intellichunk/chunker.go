@@ -0,0 +1,317 @@
+package intellichunk
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+// Chunking strategies, StrategyLLM by default. StrategySemantic is with SemanticChunker.
+const (
+	// StrategyLLM asks the function model to split the text and write the keywords and questions of every node.
+	StrategyLLM = "llm"
+	// StrategyToken cuts the text into windows of tokens overlapping the previous one.
+	StrategyToken = "token"
+	// StrategyRecursive splits the text by paragraphs, then the paragraphs too long by sentences, then by words.
+	StrategyRecursive = "recursive"
+	// StrategyMarkdown splits the text by its Markdown headings, then the sections too long like StrategyRecursive.
+	StrategyMarkdown = "markdown"
+)
+
+const (
+	// DefaultChunkTokens is the size of the nodes of the deterministic chunkers, around the 200 words asked to the model.
+	DefaultChunkTokens = 256
+	// DefaultChunkOverlap is the number of tokens a StrategyToken node repeats from the previous one.
+	DefaultChunkOverlap = 32
+)
+
+// _maxTitleLength is the length in runes of the titles taken from the first line of the texts.
+const _maxTitleLength = 100
+
+// Chunker splits a text into container nodes without a language model, the nodes have no keywords or questions.
+// The title of the container is taken from the text.
+type Chunker interface {
+	Chunk(ctx context.Context, text string) (models.DataContainer, error)
+}
+
+// Strategies returns the names of the chunking strategies.
+func Strategies() []string {
+	strategies := []string{StrategyLLM, StrategyToken, StrategyRecursive, StrategyMarkdown, StrategySemantic}
+	sort.Strings(strategies)
+	return strategies
+}
+
+// NewChunker returns the Chunker of the strategy, counting tokens with the tokenizer and embedding sentences with the embedder.
+// StrategyLLM has none, the Ingestor splits the texts with its function model.
+func NewChunker(strategy string, tokenizer TextTokenizer, embedder llm.Embedder) (Chunker, error) {
+	switch strategy {
+	case "", StrategyLLM:
+		return nil, nil
+	case StrategyToken:
+		return &TokenChunker{Tokenizer: tokenizer, Tokens: DefaultChunkTokens, Overlap: DefaultChunkOverlap}, nil
+	case StrategyRecursive:
+		return &RecursiveChunker{Tokenizer: tokenizer, Tokens: DefaultChunkTokens}, nil
+	case StrategyMarkdown:
+		return &MarkdownChunker{RecursiveChunker{Tokenizer: tokenizer, Tokens: DefaultChunkTokens}}, nil
+	case StrategySemantic:
+		return &SemanticChunker{
+			Tokenizer:  tokenizer,
+			Embedder:   embedder,
+			Percentile: DefaultSemanticPercentile,
+			MinTokens:  DefaultSemanticMinTokens,
+			MaxTokens:  DefaultChunkTokens,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown chunking strategy %q, expected one of %s", strategy, strings.Join(Strategies(), ", "))
+}
+
+// WithStrategy sets the chunking strategy by name, see Strategies.
+func WithStrategy(strategy string) Option {
+	return func(i *Ingestor) {
+		i.strategy = strategy
+	}
+}
+
+// WithChunker sets the Chunker splitting the texts instead of the function model.
+func WithChunker(chunker Chunker) Option {
+	return func(i *Ingestor) {
+		i.chunker = chunker
+	}
+}
+
+// TokenChunker cuts the texts into windows of Tokens tokens, each repeating the last Overlap tokens of the previous one.
+type TokenChunker struct {
+	Tokenizer TextTokenizer
+	Tokens    int
+	Overlap   int
+}
+
+// Chunk cuts the text into overlapping windows of tokens.
+func (c *TokenChunker) Chunk(ctx context.Context, text string) (models.DataContainer, error) {
+	if c.Tokens <= 0 || c.Overlap < 0 || c.Overlap >= c.Tokens {
+		return models.DataContainer{}, fmt.Errorf("invalid chunks of %d tokens overlapping by %d", c.Tokens, c.Overlap)
+	}
+	return container(titleOf(text), tokenWindows(c.Tokenizer, text, c.Tokens, c.Overlap), nil), nil
+}
+
+// _separators split the texts by paragraphs, lines, sentences and words. The separators stay at the end of the pieces.
+var _separators = []*regexp.Regexp{
+	regexp.MustCompile(`\n[ \t]*\n\s*`),
+	regexp.MustCompile(`\n\s*`),
+	regexp.MustCompile(`[.!?]["')\]]*\s+`),
+	regexp.MustCompile(`\s+`),
+}
+
+// RecursiveChunker splits the texts by paragraphs, the paragraphs longer than Tokens by sentences, then by words,
+// and merges the consecutive pieces into nodes of at most Tokens tokens.
+type RecursiveChunker struct {
+	Tokenizer TextTokenizer
+	Tokens    int
+}
+
+// Chunk splits the text by paragraphs, sentences and words.
+func (c *RecursiveChunker) Chunk(ctx context.Context, text string) (models.DataContainer, error) {
+	if c.Tokens <= 0 {
+		return models.DataContainer{}, fmt.Errorf("invalid chunks of %d tokens", c.Tokens)
+	}
+	return container(titleOf(text), c.split(newTokenCounter(c.Tokenizer), text), nil), nil
+}
+
+// split splits the text into chunks of at most Tokens tokens, unless a word is longer.
+func (c *RecursiveChunker) split(counter *tokenCounter, text string) []string {
+	var chunks []string
+	for _, chunk := range c.splitWith(counter, text, _separators) {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func (c *RecursiveChunker) splitWith(counter *tokenCounter, text string, separators []*regexp.Regexp) []string {
+	if len(separators) == 0 || counter.count(text) <= c.Tokens {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	currentTokens := 0
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentTokens = 0
+		}
+	}
+	for _, piece := range splitAfter(text, separators[0]) {
+		tokens := counter.count(piece)
+		if tokens > c.Tokens {
+			// Too long for a chunk, split it finer.
+			flush()
+			chunks = append(chunks, c.splitWith(counter, piece, separators[1:])...)
+			continue
+		}
+		if currentTokens+tokens > c.Tokens {
+			flush()
+		}
+		current.WriteString(piece)
+		currentTokens += tokens
+	}
+	flush()
+	return chunks
+}
+
+// splitAfter splits the text after every match of the separator.
+func splitAfter(text string, separator *regexp.Regexp) []string {
+	var pieces []string
+	last := 0
+	for _, match := range separator.FindAllStringIndex(text, -1) {
+		if match[1] > last {
+			pieces = append(pieces, text[last:match[1]])
+			last = match[1]
+		}
+	}
+	if last < len(text) {
+		pieces = append(pieces, text[last:])
+	}
+	return pieces
+}
+
+// _heading matches the Markdown ATX headings, e.g. "## Climate".
+var _heading = regexp.MustCompile(`^(#{1,6})[ \t]+(.+?)[ \t#]*$`)
+
+// MarkdownChunker splits the texts by their Markdown headings, and the sections longer than Tokens like RecursiveChunker.
+// The keywords of the nodes are the headings of their section, the title of the container is the first top heading.
+type MarkdownChunker struct {
+	RecursiveChunker
+}
+
+// Chunk splits the text by its headings.
+func (c *MarkdownChunker) Chunk(ctx context.Context, text string) (models.DataContainer, error) {
+	if c.Tokens <= 0 {
+		return models.DataContainer{}, fmt.Errorf("invalid chunks of %d tokens", c.Tokens)
+	}
+	counter := newTokenCounter(c.Tokenizer)
+
+	title := ""
+	var chunks []string
+	var keywords [][]string
+	// headings holds the current heading of every level.
+	headings := make([]string, 6)
+	var section strings.Builder
+	hasBody := false
+	flush := func() {
+		if hasBody {
+			path := headingPath(headings)
+			for _, chunk := range c.split(counter, section.String()) {
+				chunks = append(chunks, chunk)
+				keywords = append(keywords, path)
+			}
+		}
+		section.Reset()
+		hasBody = false
+	}
+
+	fenced := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+		if match := _heading.FindStringSubmatch(trimmed); match != nil && !fenced {
+			level := len(match[1])
+			// A heading without text under it stays in the path of the sections below it.
+			if hasBody {
+				flush()
+			}
+			headings[level-1] = match[2]
+			for deeper := level; deeper < len(headings); deeper++ {
+				headings[deeper] = ""
+			}
+			if title == "" && level == 1 {
+				title = match[2]
+			}
+			section.WriteString(line)
+			continue
+		}
+		if trimmed != "" {
+			hasBody = true
+		}
+		section.WriteString(line)
+	}
+	flush()
+
+	if title == "" {
+		title = titleOf(text)
+	}
+	return container(title, chunks, keywords), nil
+}
+
+// headingPath returns the headings of the section, from the top one.
+func headingPath(headings []string) []string {
+	var path []string
+	for _, heading := range headings {
+		if heading != "" {
+			path = append(path, heading)
+		}
+	}
+	return path
+}
+
+// container returns the container of the chunks, numbered in order.
+func container(title string, chunks []string, keywords [][]string) models.DataContainer {
+	c := models.DataContainer{Title: title, Nodes: make([]models.ContainerNode, 0, len(chunks))}
+	for n, chunk := range chunks {
+		node := models.ContainerNode{Content: strings.TrimSpace(chunk), Keywords: []string{}, Questions: []string{}, NodeNumber: n + 1}
+		if keywords != nil && keywords[n] != nil {
+			node.Keywords = keywords[n]
+		}
+		c.Nodes = append(c.Nodes, node)
+	}
+	return c
+}
+
+// titleOf returns the first line of the text, without Markdown heading marks and shortened to _maxTitleLength runes.
+func titleOf(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line == "" {
+			continue
+		}
+		if utf8.RuneCountInString(line) > _maxTitleLength {
+			line = string([]rune(line)[:_maxTitleLength])
+		}
+		return line
+	}
+	return ""
+}
+
+// tokenCounter counts tokens with a tokenizer, and estimates them once the tokenizer failed,
+// so a tokenizer that can't load its encoding isn't asked for every piece.
+type tokenCounter struct {
+	tokenizer TextTokenizer
+	failed    bool
+}
+
+func newTokenCounter(tokenizer TextTokenizer) *tokenCounter {
+	return &tokenCounter{tokenizer: tokenizer}
+}
+
+func (c *tokenCounter) count(text string) int {
+	if !c.failed {
+		tokens, err := c.tokenizer.Tokenize(text)
+		if err == nil {
+			return len(tokens)
+		}
+		log.Warningf("Failed to count tokens, estimating them: %v", err)
+		c.failed = true
+	}
+	return (utf8.RuneCountInString(text) + _charsPerToken - 1) / _charsPerToken
+}
@@ -0,0 +1,136 @@
+package intellichunk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+// WithNodeMetadata sets whether the function model writes the title, summary, keywords and questions of the nodes
+// split by a Chunker. The model only reads the nodes instead of writing them, which is much cheaper than StrategyLLM.
+func WithNodeMetadata(enabled bool) Option {
+	return func(i *Ingestor) {
+		i.nodeMetadata = enabled
+	}
+}
+
+// describe asks the function model for the keywords and questions of the nodes, and the title and summary of the text,
+// a window of nodes at a time. Nodes the model fails to describe keep the metadata of the chunker.
+func (i *Ingestor) describe(container models.DataContainer) models.DataContainer {
+	counter := newTokenCounter(i.tokenizer)
+	var batches [][]models.ContainerNode
+	batchTokens := 0
+	for _, node := range container.Nodes {
+		tokens := counter.count(node.Content)
+		if len(batches) == 0 || batchTokens+tokens > i.windowTokens {
+			batches = append(batches, nil)
+			batchTokens = 0
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], node)
+		batchTokens += tokens
+	}
+
+	byNumber := make(map[int]int, len(container.Nodes))
+	for n, node := range container.Nodes {
+		byNumber[node.NodeNumber] = n
+	}
+	var described []models.DataContainer
+	for b, batch := range batches {
+		batchContainer, err := i.describeNodes(batch)
+		if err != nil {
+			log.Warningf("Failed to describe the nodes of window %d of %d, keeping them without keywords and questions: %v", b+1, len(batches), err)
+			continue
+		}
+		for _, node := range batchContainer.Nodes {
+			n, ok := byNumber[node.NodeNumber]
+			if !ok {
+				continue
+			}
+			container.Nodes[n].Keywords = append(container.Nodes[n].Keywords, node.Keywords...)
+			container.Nodes[n].Questions = append(container.Nodes[n].Questions, node.Questions...)
+		}
+		described = append(described, batchContainer)
+	}
+
+	switch {
+	case len(described) == 1:
+		container.Title, container.Summary, container.AbstactSum = described[0].Title, described[0].Summary, described[0].AbstactSum
+	case len(described) > 1:
+		container.Title, container.Summary, container.AbstactSum = i.summarizeWindows(described)
+	}
+	return container
+}
+
+// describeNodes asks the function model for the metadata of consecutive nodes, they are identified by their section number.
+func (i *Ingestor) describeNodes(nodes []models.ContainerNode) (models.DataContainer, error) {
+	nodeSchema := &models.Definition{
+		Type: models.Object,
+		Properties: map[string]models.Definition{
+			"sectionNumber": {
+				Type:        models.Integer,
+				Description: "The number of the section in square brackets",
+			},
+			"keywords": {
+				Type:        models.Array,
+				Items:       &models.Definition{Type: models.String},
+				Description: "The array of 3 keywords related to this section",
+			},
+			"questions": {
+				Type:        models.Array,
+				Items:       &models.Definition{Type: models.String},
+				Description: "The array of 2 questions this section can answer",
+			},
+		},
+		Required: []string{"sectionNumber", "keywords", "questions"},
+	}
+	funcDef := []models.FunctionDefinition{
+		{
+			Name:        "describe_sections",
+			Description: "Describes the sections of an input",
+			Parameters: models.Definition{
+				Type: models.Object,
+				Properties: map[string]models.Definition{
+					"title": {
+						Type:        models.String,
+						Description: "Title of the whole input",
+					},
+					"summary": {
+						Type:        models.String,
+						Description: "Summary of the whole input.",
+					},
+					"abstract_description": {
+						Type:        models.String,
+						Description: "Very abstract description of the input",
+					},
+					"nodes": {
+						Type:        models.Array,
+						Items:       nodeSchema,
+						Description: "The keywords and questions of every section",
+					},
+				},
+				Required: []string{"title", "summary", "abstract_description", "nodes"},
+			},
+		},
+	}
+
+	var sections strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&sections, "[%d] %s\n\n", node.NodeNumber, node.Content)
+	}
+	prompt := "Create a single paragraph summary, an abstract description that describes the input and a title considering unique entities found in the following input, which is split into numbered sections. For each section add 3 relevant keywords from that section and 2 questions this section can provide specific answers to which are unlikely to be found elsewhere. Don't rewrite the sections.\n\n Input:\n" + sections.String()
+
+	resp, err := i.functionModel.ChatCompletionFunctionsOptions(context.Background(), prompt, funcDef, llm.WithTemperature(0.3))
+	if err != nil {
+		return models.DataContainer{}, err
+	}
+	var container models.DataContainer
+	if err := json.Unmarshal([]byte(resp), &container); err != nil {
+		return models.DataContainer{}, err
+	}
+	return container, nil
+}
@@ -0,0 +1,283 @@
+package intellichunk
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+const (
+	// DefaultMinNodeMatch is the share of the words of a node found in order in the source below which it is repaired.
+	DefaultMinNodeMatch = 0.9
+	// DefaultMinCoverage is the share of the words of the source the nodes must hold before it is split again.
+	DefaultMinCoverage = 0.9
+	// DefaultReprompts is the number of times a split covering too little of the source is asked again.
+	DefaultReprompts = 1
+)
+
+// _minRepairMatch is the match below which a node isn't from the source at all, repairing drops it.
+const _minRepairMatch = 0.5
+
+// _minUnalignedWords is the number of consecutive words of the source left out by the nodes reported as an unaligned span.
+const _minUnalignedWords = 3
+
+// _alignmentSlack is the number of words past the expected end of a node its alignment is searched in,
+// before searching the whole source.
+const _alignmentSlack = 50
+
+// _repromptInstructions are added to the split prompt when the previous split left out or rewrote the input.
+const _repromptInstructions = " The previous split rewrote or left out parts of the input: copy the input verbatim into the content of the nodes, without leaving anything out."
+
+// Fidelity sets how closely the nodes split by the function model must hold the source text.
+// The model is asked to split the input but may paraphrase, drop or make up text.
+type Fidelity struct {
+	// MinNodeMatch is the share of the words of a node found in order in the source below which the node is repaired.
+	MinNodeMatch float64
+	// MinCoverage is the share of the words of the source the nodes must hold, below it the source is split again.
+	MinCoverage float64
+	// Reprompts is the number of times the source is split again, the split covering most of it is kept.
+	Reprompts int
+	// Repair replaces the content of the nodes under MinNodeMatch with the source text they align with,
+	// and drops the ones that aren't from the source. Without it the nodes are only reported.
+	Repair bool
+}
+
+// FidelityReport tells how closely nodes hold their source text.
+type FidelityReport struct {
+	// Coverage is the share of the words of the source held by the nodes.
+	Coverage float64 `json:"coverage"`
+	// Nodes are the alignments of the nodes, in their order.
+	Nodes []NodeAlignment `json:"nodes"`
+	// Unaligned are the spans of the source held by no node.
+	Unaligned []Span `json:"unaligned,omitempty"`
+	// Repaired and Dropped count the nodes changed by the repair.
+	Repaired int `json:"repaired,omitempty"`
+	Dropped  int `json:"dropped,omitempty"`
+}
+
+// NodeAlignment is the span of the source a node aligns with.
+type NodeAlignment struct {
+	Span
+	// Match is the share of the words of the node found in order in the span.
+	Match float64 `json:"match"`
+}
+
+// Span is a part of the source, Start and End are byte offsets.
+type Span struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text,omitempty"`
+}
+
+// WithFidelity sets how closely the nodes must hold the source text, DefaultMinNodeMatch, DefaultMinCoverage
+// and DefaultReprompts with repairs by default.
+func WithFidelity(fidelity Fidelity) Option {
+	return func(i *Ingestor) {
+		i.fidelity = fidelity
+	}
+}
+
+// validate checks the thresholds are shares and the source is split at least once.
+func (f Fidelity) validate() error {
+	if f.MinNodeMatch < 0 || f.MinNodeMatch > 1 || f.MinCoverage < 0 || f.MinCoverage > 1 {
+		return fmt.Errorf("invalid fidelity thresholds %v and %v, they must be between 0 and 1", f.MinNodeMatch, f.MinCoverage)
+	}
+	if f.Reprompts < 0 {
+		return fmt.Errorf("invalid fidelity reprompts %d, they can't be negative", f.Reprompts)
+	}
+	return nil
+}
+
+// VerifyFidelity aligns the content of every node with the source text, in order, and reports the coverage of the source.
+// The words are matched lower cased without punctuation, and the alignment allows words changed, added or left out.
+func VerifyFidelity(source string, nodes []models.ContainerNode) FidelityReport {
+	sourceWords := splitWords(source)
+	covered := make([]bool, len(sourceWords))
+	report := FidelityReport{Nodes: make([]NodeAlignment, 0, len(nodes))}
+
+	next := 0
+	for _, node := range nodes {
+		nodeWords := splitWords(node.Content)
+		// Nodes follow the source, search after the previous one first.
+		start, end, matched := align(nodeWords, sourceWords, next, next+2*len(nodeWords)+_alignmentSlack)
+		if float64(matched) < _minRepairMatch*float64(len(nodeWords)) {
+			start, end, matched = align(nodeWords, sourceWords, 0, len(sourceWords))
+		}
+
+		alignment := NodeAlignment{}
+		if len(nodeWords) > 0 {
+			alignment.Match = float64(matched) / float64(len(nodeWords))
+		}
+		if end > start {
+			alignment.Span = Span{Start: sourceWords[start].start, End: punctuationEnd(source, sourceWords[end-1].end)}
+			alignment.Text = source[alignment.Start:alignment.End]
+			if alignment.Match >= _minRepairMatch {
+				for w := start; w < end; w++ {
+					covered[w] = true
+				}
+				next = end
+			}
+		}
+		report.Nodes = append(report.Nodes, alignment)
+	}
+
+	held := 0
+	for w := 0; w < len(sourceWords); w++ {
+		if covered[w] {
+			held++
+			continue
+		}
+		run := w
+		for run < len(sourceWords) && !covered[run] {
+			run++
+		}
+		if run-w >= _minUnalignedWords {
+			span := Span{Start: sourceWords[w].start, End: sourceWords[run-1].end}
+			span.Text = source[span.Start:span.End]
+			report.Unaligned = append(report.Unaligned, span)
+		}
+		w = run - 1
+	}
+	report.Coverage = 1
+	if len(sourceWords) > 0 {
+		report.Coverage = float64(held) / float64(len(sourceWords))
+	}
+	return report
+}
+
+// logReport logs the report of the article, without the source text of the nodes.
+func logReport(title string, report FidelityReport) {
+	nodes := make([]NodeAlignment, len(report.Nodes))
+	for n, node := range report.Nodes {
+		nodes[n] = node
+		nodes[n].Text = ""
+	}
+	report.Nodes = nodes
+	log.Infoj("Fidelity of the nodes of "+title, report)
+}
+
+// repair replaces the content of the nodes under MinNodeMatch with the source text they align with,
+// and drops the nodes that aren't from the source.
+func (f Fidelity) repair(nodes []models.ContainerNode, report *FidelityReport) []models.ContainerNode {
+	repaired := make([]models.ContainerNode, 0, len(nodes))
+	for n, node := range nodes {
+		alignment := report.Nodes[n]
+		switch {
+		case alignment.Match >= f.MinNodeMatch:
+		case alignment.Match < _minRepairMatch || alignment.Text == "":
+			log.Warningf("Dropping node %d, it isn't from the source: %q", node.NodeNumber, node.Content)
+			report.Dropped++
+			continue
+		default:
+			log.Warningf("Repairing node %d matching %.0f%% of the source with the source text", node.NodeNumber, 100*alignment.Match)
+			node.Content = alignment.Text
+			report.Repaired++
+		}
+		repaired = append(repaired, node)
+	}
+	return repaired
+}
+
+// punctuationEnd returns the offset after the punctuation following offset, to end a span with its sentence.
+func punctuationEnd(source string, offset int) int {
+	for _, r := range source[offset:] {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsNumber(r) {
+			break
+		}
+		offset += utf8.RuneLen(r)
+	}
+	return offset
+}
+
+// sourceWord is a word of the source lower cased, with its byte offsets.
+type sourceWord struct {
+	text       string
+	start, end int
+}
+
+// splitWords returns the lower cased words of the text, without punctuation.
+func splitWords(text string) []sourceWord {
+	var words []sourceWord
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, sourceWord{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, sourceWord{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return words
+}
+
+// align finds the span of source[from:to] best aligned with the node words, allowing words changed, added or left out
+// (Smith-Waterman over the words). It returns the span as word indexes of source and the number of words matched in it.
+func align(node, source []sourceWord, from, to int) (start, end, matched int) {
+	const (
+		matchScore = 2
+		mismatch   = -1
+		gap        = -1
+	)
+	if from < 0 {
+		from = 0
+	}
+	if to > len(source) {
+		to = len(source)
+	}
+	if len(node) == 0 || from >= to {
+		return 0, 0, 0
+	}
+
+	width := to - from + 1
+	// Every cell carries the score of the best alignment ending there, with its start in the source and its matches.
+	type cell struct{ score, start, matched int }
+	previous, current := make([]cell, width), make([]cell, width)
+	best := cell{}
+	bestEnd := 0
+	for i := 1; i <= len(node); i++ {
+		current[0] = cell{}
+		for j := 1; j < width; j++ {
+			w := from + j - 1
+			diagonal := previous[j-1]
+			if diagonal.score == 0 {
+				diagonal = cell{start: w}
+			}
+			candidate := diagonal
+			if node[i-1].text == source[w].text {
+				candidate.score += matchScore
+				candidate.matched++
+			} else {
+				candidate.score += mismatch
+			}
+			if up := previous[j]; up.score+gap > candidate.score {
+				candidate = cell{score: up.score + gap, start: up.start, matched: up.matched}
+			}
+			if left := current[j-1]; left.score+gap > candidate.score {
+				candidate = cell{score: left.score + gap, start: left.start, matched: left.matched}
+			}
+			if candidate.score <= 0 {
+				candidate = cell{}
+			}
+			current[j] = candidate
+			if candidate.score > best.score {
+				best, bestEnd = candidate, w+1
+			}
+		}
+		previous, current = current, previous
+	}
+	if best.score == 0 {
+		return 0, 0, 0
+	}
+	return best.start, bestEnd, best.matched
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/apsystole/log"
 	"github.com/cckalen/intellichunk/internal/llm"
@@ -16,11 +19,237 @@ import (
 	"github.com/cckalen/intellichunk/internal/vectorstore"
 )
 
+// Ingestor splits texts into container nodes, embeds them and adds them to a vectorstore.
+// The package level functions use an Ingestor built from the configured providers,
+// tests and other callers can inject their own models and store with the options.
+type Ingestor struct {
+	functionModel llm.FunctionModel
+	embedder      llm.Embedder
+	store         vectorstore.VectorStore
+	// tokenizer cuts the texts too long for the function model into windows of windowTokens, overlapping by windowOverlap.
+	tokenizer     TextTokenizer
+	windowTokens  int
+	windowOverlap int
+	fidelity      Fidelity
+	// strategy names the chunker splitting the texts instead of the function model, if any.
+	strategy string
+	chunker  Chunker
+	// nodeMetadata has the function model describe the nodes split by the chunker.
+	nodeMetadata bool
+}
+
+// Option is a function that can modify the Ingestor configuration.
+type Option func(*Ingestor)
+
+// WithFunctionModel sets the model used to split texts into container nodes.
+func WithFunctionModel(functionModel llm.FunctionModel) Option {
+	return func(i *Ingestor) {
+		i.functionModel = functionModel
+	}
+}
+
+// WithEmbedder sets the model used to embed the container nodes.
+func WithEmbedder(embedder llm.Embedder) Option {
+	return func(i *Ingestor) {
+		i.embedder = embedder
+	}
+}
+
+// WithStore sets the vectorstore the container nodes are added to.
+func WithStore(store vectorstore.VectorStore) Option {
+	return func(i *Ingestor) {
+		i.store = store
+	}
+}
+
+// NewIngestor creates an Ingestor, anything not set with an option comes from the llm and vectorstore registries.
+func NewIngestor(opts ...Option) (*Ingestor, error) {
+	ingestor := &Ingestor{
+		tokenizer:     NewTokenizer(),
+		windowTokens:  DefaultWindowTokens,
+		windowOverlap: DefaultWindowOverlap,
+		fidelity:      Fidelity{MinNodeMatch: DefaultMinNodeMatch, MinCoverage: DefaultMinCoverage, Reprompts: DefaultReprompts, Repair: true},
+	}
+	for _, opt := range opts {
+		opt(ingestor)
+	}
+
+	if ingestor.windowTokens <= 0 || ingestor.windowOverlap < 0 || ingestor.windowOverlap >= ingestor.windowTokens {
+		return nil, fmt.Errorf("invalid window of %d tokens overlapping by %d", ingestor.windowTokens, ingestor.windowOverlap)
+	}
+	if err := ingestor.fidelity.validate(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if ingestor.functionModel == nil {
+		ingestor.functionModel, err = llm.NewFunctionModel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ingestor.embedder == nil {
+		ingestor.embedder, err = llm.NewEmbedder()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ingestor.store == nil {
+		ingestor.store, err = vectorstore.New()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ingestor.chunker == nil {
+		ingestor.chunker, err = NewChunker(ingestor.strategy, ingestor.tokenizer, ingestor.embedder)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ingestor, nil
+}
+
+// SplitTextIntoContainerNodes splits longText with an Ingestor built from the configured providers.
+func SplitTextIntoContainerNodes(longText string) (chunkedResp string, err error) {
+	ingestor, err := NewIngestor()
+	if err != nil {
+		return "", err
+	}
+	return ingestor.SplitTextIntoContainerNodes(longText)
+}
+
+// GenerateContainerNodes embeds the nodes with an Ingestor built from the configured providers.
+func GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []models.ContainerNodeVector, err error) {
+	ingestor, err := NewIngestor()
+	if err != nil {
+		return nodes, err
+	}
+	return ingestor.GenerateContainerNodes(chunkedResp, reftitle, refUrl)
+}
+
+// Add adds longText to the vectorstore with an Ingestor built from the configured providers.
+func Add(className, longText string) (objIDs []string, err error) {
+	ingestor, err := NewIngestor()
+	if err != nil {
+		return objIDs, err
+	}
+	return ingestor.Add(className, longText)
+}
+
+// AddFromFolder adds the articles of a folder with an Ingestor built from the configured providers.
+func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []string, err error) {
+	ingestor, err := NewIngestor()
+	if err != nil {
+		return objIDs, err
+	}
+	return ingestor.AddFromFolder(className, folderPath, saveToFile)
+}
+
+// AddWithoutNodes adds generic objects with an Ingestor built from the configured providers.
+func AddWithoutNodes(className string, dataObjects []models.GeneralDataHolder) (objIDs []string, err error) {
+	ingestor, err := NewIngestor()
+	if err != nil {
+		return objIDs, err
+	}
+	return ingestor.AddWithoutNodes(className, dataObjects)
+}
+
 // SplitTextIntoContainerNodes function takes a long text as input and splits it into smaller sections/nodes,
 // each containing around 300 characters. It also adds relevant metadata to each section,
 // including 5 keywords and 2 specific questions that the section can answer.
+// Texts longer than the window of the Ingestor are split a window at a time, and the nodes of the windows merged.
+// The nodes are verified against the text, see Fidelity, and the result is logged.
+// Every node carries the offsets of its content in the text, unless it isn't from the text.
+// With a Chunker, the text is split by the chunker instead, without keywords and questions unless WithNodeMetadata is set.
 // The function returns the resulting text in a stringfied JSON format representing the nodes.
-func SplitTextIntoContainerNodes(longText string) (chunkedResp string, err error) {
+func (i *Ingestor) SplitTextIntoContainerNodes(longText string) (chunkedResp string, err error) {
+	if i.chunker != nil {
+		container, err := i.chunker.Chunk(context.Background(), longText)
+		if err != nil {
+			return "", err
+		}
+		if i.nodeMetadata {
+			container = i.describe(container)
+		}
+		locate(longText, container.Nodes, nil)
+		data, err := json.Marshal(container)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	windows := i.windows(longText)
+	containers := make([]models.DataContainer, 0, len(windows))
+	repaired, dropped := 0, 0
+	for n, window := range windows {
+		if len(windows) > 1 {
+			log.Println("Splitting window", n+1, "of", len(windows))
+		}
+		container, report, err := i.splitWindow(window.text)
+		if err != nil {
+			if len(windows) > 1 {
+				err = fmt.Errorf("failed to split window %d of %d: %w", n+1, len(windows), err)
+			}
+			return "", err
+		}
+		locateInWindow(window, container.Nodes)
+		containers = append(containers, container)
+		repaired, dropped = repaired+report.Repaired, dropped+report.Dropped
+	}
+
+	container := containers[0]
+	if len(containers) > 1 {
+		container = i.mergeWindows(windows, containers)
+	}
+	report := VerifyFidelity(longText, container.Nodes)
+	report.Repaired, report.Dropped = repaired, dropped
+	logReport(container.Title, report)
+
+	data, err := json.Marshal(container)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// splitWindow splits a text fitting the context of the function model into container nodes holding the text.
+// Splits covering too little of the text are asked again, the nodes of the best one are repaired.
+func (i *Ingestor) splitWindow(longText string) (models.DataContainer, FidelityReport, error) {
+	var best models.DataContainer
+	var bestReport FidelityReport
+	instructions := ""
+	for attempt := 0; attempt <= i.fidelity.Reprompts; attempt++ {
+		container, err := i.splitText(longText, instructions)
+		if err != nil {
+			if attempt == 0 {
+				return container, bestReport, err
+			}
+			// The previous split is still usable.
+			log.Warningf("Failed to split the input again, keeping the previous split: %v", err)
+			break
+		}
+
+		report := VerifyFidelity(longText, container.Nodes)
+		if attempt == 0 || report.Coverage > bestReport.Coverage {
+			best, bestReport = container, report
+		}
+		if report.Coverage >= i.fidelity.MinCoverage || attempt == i.fidelity.Reprompts {
+			break
+		}
+		log.Warningf("The nodes hold %.0f%% of the input, splitting it again", 100*report.Coverage)
+		instructions = _repromptInstructions
+	}
+
+	if i.fidelity.Repair {
+		best.Nodes = i.fidelity.repair(best.Nodes, &bestReport)
+	}
+	return best, bestReport, nil
+}
+
+// splitText asks the function model to split a text fitting its context into container nodes,
+// following the additional instructions if any.
+func (i *Ingestor) splitText(longText, instructions string) (container models.DataContainer, err error) {
 	// Define the JSON schema for Sections
 	nodesSchema := &models.Definition{
 		Type: models.Object,
@@ -77,18 +306,16 @@ func SplitTextIntoContainerNodes(longText string) (chunkedResp string, err error
 		},
 	}
 
-	var container models.DataContainer
-	// Create a new OpenAI language model instance.
-	languageModel := llm.NewOpenAI()
 	llmOptions := []llm.LLMOption{
 		llm.WithTemperature(0.3),
 	}
 
 	jsonString := `{"title": "Title", "summary": "Summary", "abstract_description": "Description", "nodes": [{"content": "Content1", "keywords": ["k1", "k2", "k3", "k4", "k5"], "questions": ["Q1?", "Q2?"], "sectionNumber": 1}, {"content": "Content2", "keywords": ["k6", "k7", "k8", "k9", "k10"], "questions": ["Q3?", "Q4?"], "sectionNumber": 2}]}`
-	promptToSplit := "Create a single paragraph summary, an abstract description that describes the input and a title considering unique entities found in the following input.  Also, Split the input into smaller sections called nodes, each around 200 words(this is important), for each section add 3 relevant keywords from that section/chunk and 2 questions this section can provide specific answers to which are unlikely to be found elsewhere. Example Output: " + jsonString + "\n\n Input:" + longText
+	promptToSplit := "Create a single paragraph summary, an abstract description that describes the input and a title considering unique entities found in the following input.  Also, Split the input into smaller sections called nodes, each around 200 words(this is important), for each section add 3 relevant keywords from that section/chunk and 2 questions this section can provide specific answers to which are unlikely to be found elsewhere. Example Output: " + jsonString + instructions + "\n\n Input:" + longText
 
+	var chunkedResp string
 	for retries := 0; retries < 3; retries++ {
-		chunkedResp, err = languageModel.ChatCompletionFunctionsOptions(context.Background(), promptToSplit, funcDef, llmOptions...)
+		chunkedResp, err = i.functionModel.ChatCompletionFunctionsOptions(context.Background(), promptToSplit, funcDef, llmOptions...)
 		if err != nil {
 			log.Errorf("error : %s", err)
 			continue // Retry if there's an error
@@ -107,16 +334,22 @@ func SplitTextIntoContainerNodes(longText string) (chunkedResp string, err error
 	if err != nil {
 		// tried 3 times and still haven't got a valid response
 		log.Errorf("Failed to get a valid response after 3 attempts: %s", err)
-		return chunkedResp, err
+		return container, err
 	}
 
-	return chunkedResp, nil
+	return container, nil
 }
 
 // GenerateContainerNodes function processes the string JSON response from SplitTextIntoContainerNodes and generates container nodes
 // with additional embeddings. It returns a slice of models.ContainerNodeVector, which contains information about each node
 // along with its associated embeddings.
-func GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []models.ContainerNodeVector, err error) {
+func (i *Ingestor) GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []models.ContainerNodeVector, err error) {
+	return i.GenerateContainerNodesFromSource(chunkedResp, reftitle, refUrl, Source{})
+}
+
+// GenerateContainerNodesFromSource is GenerateContainerNodes for a text read from a file, the nodes record the file,
+// the article and their offsets in the file along with the hash of their content.
+func (i *Ingestor) GenerateContainerNodesFromSource(chunkedResp, reftitle, refUrl string, source Source) (nodes []models.ContainerNodeVector, err error) {
 
 	var container models.DataContainer
 	err = json.Unmarshal([]byte(chunkedResp), &container)
@@ -142,18 +375,20 @@ func GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []model
 		//fmt.Print("\n\n")
 	}
 
-	// Create a new OpenAI language model instance.
-	languageModel := llm.NewOpenAI()
-
 	//embedBatch holds [][]float32 of embeddings
-	embedBatch, err := languageModel.GenerateMultipleEmbeddingsFromText(context.Background(), embedTextSlice)
+	embedBatch, err := i.embedder.GenerateMultipleEmbeddingsFromText(context.Background(), embedTextSlice)
 	if err != nil {
 		log.Println("Error GenerateMultipleEmbeddingsFromText  :", err)
 		return nodes, err
 	}
+	if len(embedBatch) != len(container.Nodes) {
+		return nodes, fmt.Errorf("expected %d embeddings, one per node, got %d", len(container.Nodes), len(embedBatch))
+	}
+
+	ingestedAt := time.Now().UTC()
 
 	// Assigning each node with relavant embeddings returned
-	for i, node := range container.Nodes {
+	for n, node := range container.Nodes {
 		var cNV models.ContainerNodeVector
 		cNV.Title = container.Title
 		cNV.Summary = container.Summary
@@ -164,7 +399,9 @@ func GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []model
 		cNV.NodeNumber = node.NodeNumber
 		cNV.RefTitle = reftitle
 		cNV.ReferenceURL = refUrl
-		cNV.Embedding = embedBatch[i]
+		cNV.IngestedAt = ingestedAt
+		provenance(&cNV, node.Offsets, source)
+		cNV.Embedding = embedBatch[n]
 		nodes = append(nodes, cNV)
 	}
 
@@ -175,22 +412,21 @@ func GenerateContainerNodes(chunkedResp, reftitle, refUrl string) (nodes []model
 // It takes a class name and long text as input, splits the text into container nodes using SplitTextIntoContainerNodes,
 // generates embeddings for the nodes using GenerateContainerNodes, and finally adds the resulting objects to the vectorstore.
 // The function returns a slice of object IDs returned from the vectorstore.
-func Add(className, longText string) (objIDs []string, err error) {
+func (i *Ingestor) Add(className, longText string) (objIDs []string, err error) {
 
-	nodesInString, err := SplitTextIntoContainerNodes(longText)
+	nodesInString, err := i.SplitTextIntoContainerNodes(longText)
 	if err != nil {
 		log.Println("Error Add SplitTextIntoContainerNodes  :", err)
 		return objIDs, err
 	}
 
-	dataContainerNodes, err := GenerateContainerNodes(nodesInString, "", "")
+	dataContainerNodes, err := i.GenerateContainerNodes(nodesInString, "", "")
 	if err != nil {
 		log.Println("Error Add GenerateContainerNodes  :", err)
 		return objIDs, err
 	}
 
-	store := vectorstore.NewWeaviateStore()
-	objIDs, err = store.AddNodeObjects(className, dataContainerNodes)
+	objIDs, err = i.store.AddNodeObjects(className, dataContainerNodes)
 	if err != nil {
 		log.Println("Error Add AddObjects  :", err)
 		return objIDs, err
@@ -204,7 +440,7 @@ func Add(className, longText string) (objIDs []string, err error) {
 // Title: Another article title
 // RefURL:https://....
 // Content: Long content
-func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []string, err error) {
+func (i *Ingestor) AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []string, err error) {
 	absFolderPath, err := filepath.Abs(folderPath)
 	if err != nil {
 		util.Red("Error getting absolute path: %v\n", err)
@@ -229,14 +465,23 @@ func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []stri
 			contentWithoutBOM := strings.TrimPrefix(string(file), "\ufeff")
 			articles := strings.Split(contentWithoutBOM, "\nTitle: ")
 			firstArticle := true
-			for _, article := range articles {
+			// articleOffset is the byte offset in the file of the article split off its "Title: ", articleIndex its index among the articles.
+			articleOffset := len(file) - len(contentWithoutBOM)
+			articleIndex := -1
+			for n, article := range articles {
+				if n > 0 {
+					articleOffset += len(articles[n-1]) + len("\nTitle: ")
+				}
 				if len(article) == 0 {
 					continue
 				}
+				articleIndex++
 
-				// Adding "Title: " back to the start of the article string
+				// Adding "Title: " back to the start of the article string, articleStart is its offset in the file
+				articleStart := articleOffset
 				if !firstArticle {
 					article = "Title: " + article
+					articleStart -= len("Title: ")
 				}
 				firstArticle = false
 
@@ -253,14 +498,16 @@ func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []stri
 
 				refURL := strings.TrimSpace(article[refURLStart : longTextStart-len("\nContent:")])
 				longText := strings.TrimSpace(article[longTextStart:])
+				textOffset := articleStart + longTextStart + len(article[longTextStart:]) - len(strings.TrimLeftFunc(article[longTextStart:], unicode.IsSpace))
+				source := Source{Path: path, Article: articleIndex, Offset: textOffset, CharOffset: utf8.RuneCount(file[:textOffset])}
 
-				nodesInString, err := SplitTextIntoContainerNodes(longText)
+				nodesInString, err := i.SplitTextIntoContainerNodes(longText)
 				if err != nil {
 					util.Red("--------> Skipping this article! --%s-- \n Err:  %v\n", refTitle, err)
 					continue // skip this article and move to the next one
 				}
 
-				dataContainerNodes, err := GenerateContainerNodes(nodesInString, refTitle, refURL)
+				dataContainerNodes, err := i.GenerateContainerNodesFromSource(nodesInString, refTitle, refURL, source)
 				if err != nil {
 					util.Red("--------> Error:GenerateContainerNodes. -- Skipping this article! \n %v\n", err)
 					continue // skip this article and move to the next one
@@ -282,8 +529,8 @@ func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []stri
 					copy(dataWithoutEmbedding, dataContainerNodes)
 
 					// Iterate over the copy and set the Embedding field to nil
-					for i := range dataWithoutEmbedding {
-						dataWithoutEmbedding[i].Embedding = nil
+					for n := range dataWithoutEmbedding {
+						dataWithoutEmbedding[n].Embedding = nil
 					}
 
 					// Create a new JSON encoder and write the nodes to the file
@@ -296,8 +543,7 @@ func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []stri
 					}
 				}
 
-				store := vectorstore.NewWeaviateStore()
-				fileObjIDs, err := store.AddNodeObjects(className, dataContainerNodes)
+				fileObjIDs, err := i.store.AddNodeObjects(className, dataContainerNodes)
 				if err != nil {
 					util.Red("--------> Skipping this article! --%s-- \n Err:  %v\n", refTitle, err)
 					continue // skip this article and move to the next one
@@ -319,7 +565,8 @@ func AddFromFolder(className, folderPath string, saveToFile bool) (objIDs []stri
 	return objIDs, nil
 }
 
-func AddWithoutNodes(className string, dataObjects []models.GeneralDataHolder) (objIDs []string, err error) {
+// AddWithoutNodes embeds generic objects as they are, without splitting them, and adds them to the vectorstore.
+func (i *Ingestor) AddWithoutNodes(className string, dataObjects []models.GeneralDataHolder) (objIDs []string, err error) {
 
 	var embedTextSlice []string
 	for _, dObj := range dataObjects {
@@ -327,22 +574,21 @@ func AddWithoutNodes(className string, dataObjects []models.GeneralDataHolder) (
 		embedTextSlice = append(embedTextSlice, mergedText)
 	}
 
-	// Create a new OpenAI language model instance.
-	languageModel := llm.NewOpenAI()
-
 	//embedBatch holds [][]float32 of embeddings
-	embedBatch, err := languageModel.GenerateMultipleEmbeddingsFromText(context.Background(), embedTextSlice)
+	embedBatch, err := i.embedder.GenerateMultipleEmbeddingsFromText(context.Background(), embedTextSlice)
 	if err != nil {
 		log.Println("Error GenerateMultipleEmbeddingsFromText  :", err)
 		return objIDs, err
 	}
+	if len(embedBatch) != len(dataObjects) {
+		return objIDs, fmt.Errorf("expected %d embeddings, one per object, got %d", len(dataObjects), len(embedBatch))
+	}
 
-	for i := range dataObjects {
-		dataObjects[i].Embedding = embedBatch[i]
+	for n := range dataObjects {
+		dataObjects[n].Embedding = embedBatch[n]
 	}
 
-	store := vectorstore.NewWeaviateStore()
-	objIDs, err = store.AddGenericObjects(className, dataObjects)
+	objIDs, err = i.store.AddGenericObjects(className, dataObjects)
 	if err != nil {
 		log.Println("Error Intellichunk AddGenericObjects  :", err)
 		return objIDs, err
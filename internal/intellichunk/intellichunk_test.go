@@ -2,6 +2,8 @@ package intellichunk_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,12 +11,14 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/apsystole/log"
 	"github.com/cckalen/intellichunk/config"
 	"github.com/cckalen/intellichunk/internal/intellichunk"
 	"github.com/cckalen/intellichunk/internal/llm"
 	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
 
 	"github.com/hlindberg/testutils"
 	"github.com/sashabaranov/go-openai"
@@ -177,3 +181,389 @@ func Test_AddWithoutNodes(t *testing.T) {
 	}
 	fmt.Print(vecIDs)
 }
+
+// words is a tokenizer with a token per word, so the windows don't depend on the tiktoken encodings.
+type words struct {
+	vocabulary []string
+}
+
+func (w *words) Tokenize(text string) ([]int, error) {
+	var tokens []int
+	for _, word := range strings.Fields(text) {
+		tokens = append(tokens, len(w.vocabulary))
+		w.vocabulary = append(w.vocabulary, word)
+	}
+	return tokens, nil
+}
+
+func (w *words) Decode(tokens []int) (string, error) {
+	decoded := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		decoded = append(decoded, w.vocabulary[token])
+	}
+	return strings.Join(decoded, " "), nil
+}
+
+// byteTokens is a tokenizer of a token per byte, like the tiktoken tokens holding a part of a multi-byte character.
+type byteTokens struct{}
+
+func (byteTokens) Tokenize(text string) ([]int, error) {
+	tokens := make([]int, 0, len(text))
+	for n := 0; n < len(text); n++ {
+		tokens = append(tokens, int(text[n]))
+	}
+	return tokens, nil
+}
+
+func (byteTokens) Decode(tokens []int) (string, error) {
+	decoded := make([]byte, 0, len(tokens))
+	for _, token := range tokens {
+		decoded = append(decoded, byte(token))
+	}
+	return string(decoded), nil
+}
+
+func Test_SplitLongText(t *testing.T) {
+	fake := llm.NewFake().AddFunctionResponses(
+		`{"title": "Ladakh borders", "summary": "Borders.", "abstract_description": "A region.", "nodes": [
+			{"content": "Ladakh is bordered by Tibet to the east.", "keywords": ["Tibet"], "questions": ["What borders Ladakh?"], "sectionNumber": 1},
+			{"content": "Tourism started in", "keywords": ["tourism"], "questions": ["When?"], "sectionNumber": 2}]}`,
+		`{"title": "Ladakh tourism", "summary": "Tourism.", "abstract_description": "A region.", "nodes": [
+			{"content": "Tourism started in 1974 in Ladakh.", "keywords": ["tourism"], "questions": ["When did tourism start?"], "sectionNumber": 1},
+			{"content": "Tibet is east.", "keywords": ["Tibet"], "questions": ["Where is Tibet?"], "sectionNumber": 2}]}`,
+		`{"title": "Ladakh", "summary": "Ladakh borders Tibet and welcomes tourists.", "abstract_description": "A region of India."}`,
+	)
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithTokenizer(&words{}),
+		intellichunk.WithWindow(12, 4),
+	)
+	testutils.CheckNotError(err, t)
+
+	text := "Ladakh is bordered by Tibet to the east. Tourism started in 1974 in Ladakh. Tibet is east."
+	resp, err := ingestor.SplitTextIntoContainerNodes(text)
+	testutils.CheckNotError(err, t)
+
+	// Every window is split, the overlap is kept once and the document is summarized from the windows.
+	calls := fake.Calls()
+	testutils.CheckEqual(3, len(calls), t)
+	testutils.CheckTrue(strings.HasSuffix(calls[0].SystemMessage, "Input:Ladakh is bordered by Tibet to the east. Tourism started in 1974"), t)
+	testutils.CheckTrue(strings.HasSuffix(calls[1].SystemMessage, "Input:Tourism started in 1974 in Ladakh. Tibet is east."), t)
+	testutils.CheckEqual("summarize_document", calls[2].Function, t)
+
+	var container models.DataContainer
+	testutils.CheckNotError(json.Unmarshal([]byte(resp), &container), t)
+	testutils.CheckEqual("Ladakh", container.Title, t)
+	testutils.CheckEqual("Ladakh borders Tibet and welcomes tourists.", container.Summary, t)
+	testutils.CheckEqual(3, len(container.Nodes), t)
+	testutils.CheckEqual("Tourism started in 1974 in Ladakh.", container.Nodes[1].Content, t)
+	testutils.CheckEqual(container.Nodes[1].Content, text[container.Nodes[1].Offsets.StartOffset:container.Nodes[1].Offsets.EndOffset], t)
+	// A node after the overlap is kept, even with the words of a node of the previous window.
+	testutils.CheckEqual("Tibet is east.", container.Nodes[2].Content, t)
+	for n, node := range container.Nodes {
+		testutils.CheckEqual(n+1, node.NodeNumber, t)
+	}
+
+	// Texts that fit are split in a single call.
+	fake.AddFunctionResponses(`{"title": "Ladakh", "summary": "", "abstract_description": "", "nodes": [{"content": "Ladakh is in India.", "sectionNumber": 1}]}`)
+	_, err = ingestor.SplitTextIntoContainerNodes("Ladakh is in India.")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(4, len(fake.Calls()), t)
+
+	_, err = intellichunk.NewIngestor(intellichunk.WithFunctionModel(fake), intellichunk.WithEmbedder(fake), intellichunk.WithWindow(10, 10))
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_VerifyFidelity(t *testing.T) {
+	source := "Ladakh is bordered by Tibet to the east. Since 1974, the Government of India has encouraged tourism in Ladakh. The Indian military maintains a strong presence in the region."
+	report := intellichunk.VerifyFidelity(source, []models.ContainerNode{
+		{Content: "Ladakh is bordered by Tibet to the east.", NodeNumber: 1},
+		{Content: "Since 1974 the Indian Government has encouraged tourism in Ladakh.", NodeNumber: 2},
+		{Content: "Yaks outnumber cars on every road.", NodeNumber: 3},
+	})
+
+	testutils.CheckEqual(1.0, report.Nodes[0].Match, t)
+	testutils.CheckEqual("Ladakh is bordered by Tibet to the east.", report.Nodes[0].Text, t)
+	testutils.CheckEqual(0, report.Nodes[0].Start, t)
+	testutils.CheckNumericGreater(0.8, report.Nodes[1].Match, t)
+	testutils.CheckEqual("Since 1974, the Government of India has encouraged tourism in Ladakh.", report.Nodes[1].Text, t)
+	testutils.CheckNumericGreater(report.Nodes[2].Match, 0.5, t)
+
+	// The last sentence is held by no node.
+	testutils.CheckEqual(1, len(report.Unaligned), t)
+	testutils.CheckEqual("The Indian military maintains a strong presence in the region", report.Unaligned[0].Text, t)
+	testutils.CheckNumericGreater(0.6, report.Coverage, t)
+	testutils.CheckNumericGreater(report.Coverage, 0.7, t)
+}
+
+func Test_RepairNodes(t *testing.T) {
+	source := "Ladakh is bordered by Tibet to the east. Since 1974, the Government of India has encouraged tourism in Ladakh."
+	fake := llm.NewFake().AddFunctionResponses(
+		// Leaves the second sentence out, so it is split again.
+		`{"title": "Ladakh", "summary": "", "abstract_description": "", "nodes": [
+			{"content": "Ladakh is bordered by Tibet to the east.", "sectionNumber": 1}]}`,
+		`{"title": "Ladakh", "summary": "", "abstract_description": "", "nodes": [
+			{"content": "Ladakh is bordered by Tibet in the east.", "sectionNumber": 1},
+			{"content": "Since 1974 the Government of India has encouraged tourism in Ladakh.", "sectionNumber": 2},
+			{"content": "Yaks outnumber cars on every road.", "sectionNumber": 3}]}`,
+	)
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithTokenizer(&words{}),
+		intellichunk.WithFidelity(intellichunk.Fidelity{MinNodeMatch: 0.95, MinCoverage: 0.9, Reprompts: 1, Repair: true}),
+	)
+	testutils.CheckNotError(err, t)
+
+	resp, err := ingestor.SplitTextIntoContainerNodes(source)
+	testutils.CheckNotError(err, t)
+	calls := fake.Calls()
+	testutils.CheckEqual(2, len(calls), t)
+	testutils.CheckTrue(strings.Contains(calls[1].SystemMessage, "copy the input verbatim"), t)
+
+	// The paraphrased node gets the source text back, the one differing in punctuation only is kept and the made up one is dropped.
+	var container models.DataContainer
+	testutils.CheckNotError(json.Unmarshal([]byte(resp), &container), t)
+	testutils.CheckEqual(2, len(container.Nodes), t)
+	testutils.CheckEqual("Ladakh is bordered by Tibet to the east.", container.Nodes[0].Content, t)
+	testutils.CheckEqual("Since 1974 the Government of India has encouraged tourism in Ladakh.", container.Nodes[1].Content, t)
+
+	// Negative reprompts would never split the source, and the thresholds are shares.
+	for _, fidelity := range []intellichunk.Fidelity{{Reprompts: -1}, {MinNodeMatch: 1.5}, {MinCoverage: -0.1}} {
+		_, err = intellichunk.NewIngestor(intellichunk.WithFunctionModel(fake), intellichunk.WithEmbedder(fake), intellichunk.WithFidelity(fidelity))
+		testutils.CheckNotNil(err, t)
+	}
+}
+
+func Test_Chunkers(t *testing.T) {
+	ctx := context.Background()
+	text := "Ladakh\n\nLadakh is bordered by Tibet to the east. It extends from the Siachen Glacier to the Great Himalayas.\n\nSince 1974 tourism has been encouraged."
+
+	// Windows of 8 words repeating 2.
+	token := &intellichunk.TokenChunker{Tokenizer: &words{}, Tokens: 8, Overlap: 2}
+	container, err := token.Chunk(ctx, text)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh", container.Title, t)
+	testutils.CheckEqual(4, len(container.Nodes), t)
+	testutils.CheckEqual("Ladakh Ladakh is bordered by Tibet to the", container.Nodes[0].Content, t)
+	testutils.CheckTrue(strings.HasPrefix(container.Nodes[1].Content, "to the east."), t)
+
+	// The windows don't cut the characters of several tokens.
+	token = &intellichunk.TokenChunker{Tokenizer: byteTokens{}, Tokens: 4, Overlap: 1}
+	container, err = token.Chunk(ctx, "Lüneburg, Ærøskøbing")
+	testutils.CheckNotError(err, t)
+	for _, node := range container.Nodes {
+		testutils.CheckTrue(utf8.ValidString(node.Content), t)
+	}
+	testutils.CheckEqual("Lün", container.Nodes[0].Content, t)
+
+	// Paragraphs first, then the sentences of the paragraph too long.
+	recursive := &intellichunk.RecursiveChunker{Tokenizer: &words{}, Tokens: 10}
+	container, err = recursive.Chunk(ctx, text)
+	testutils.CheckNotError(err, t)
+	contents := make([]string, 0, len(container.Nodes))
+	for n, node := range container.Nodes {
+		testutils.CheckEqual(n+1, node.NodeNumber, t)
+		contents = append(contents, node.Content)
+	}
+	testutils.CheckEqual([]string{
+		"Ladakh",
+		"Ladakh is bordered by Tibet to the east.",
+		"It extends from the Siachen Glacier to the Great Himalayas.",
+		"Since 1974 tourism has been encouraged.",
+	}, contents, t)
+
+	// Sections by heading, with their headings as keywords.
+	markdown := "# Ladakh\n\nA region of India.\n\n## Geography\n\n### Borders\nTibet to the east.\n\n```\n# not a heading\n```\n\n## Tourism\nSince 1974."
+	chunker, err := intellichunk.NewChunker(intellichunk.StrategyMarkdown, &words{}, nil)
+	testutils.CheckNotError(err, t)
+	container, err = chunker.Chunk(ctx, markdown)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh", container.Title, t)
+	testutils.CheckEqual(3, len(container.Nodes), t)
+	testutils.CheckEqual([]string{"Ladakh"}, container.Nodes[0].Keywords, t)
+	testutils.CheckEqual([]string{"Ladakh", "Geography", "Borders"}, container.Nodes[1].Keywords, t)
+	testutils.CheckTrue(strings.Contains(container.Nodes[1].Content, "# not a heading"), t)
+	testutils.CheckEqual("## Tourism\nSince 1974.", container.Nodes[2].Content, t)
+
+	_, err = intellichunk.NewChunker("semantic-ish", &words{}, nil)
+	testutils.CheckNotNil(err, t)
+}
+
+// shortEmbedder leaves the embedding of the last text out.
+type shortEmbedder struct {
+	*llm.Fake
+}
+
+func (e shortEmbedder) GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error) {
+	embeddings, err := e.Fake.GenerateMultipleEmbeddingsFromText(ctx, multipleText)
+	return embeddings[:len(embeddings)-1], err
+}
+
+func Test_IngestWithStrategy(t *testing.T) {
+	fake := llm.NewFake()
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithTokenizer(&words{}),
+		intellichunk.WithStrategy(intellichunk.StrategyRecursive),
+	)
+	testutils.CheckNotError(err, t)
+
+	resp, err := ingestor.SplitTextIntoContainerNodes("Ladakh\n\nLadakh is bordered by Tibet to the east.")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(0, len(fake.Calls()), t)
+
+	// The nodes go through GenerateContainerNodes unchanged.
+	nodes, err := ingestor.GenerateContainerNodes(resp, "Ladakh article", "https://example.com/ladakh")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(1, len(nodes), t)
+	testutils.CheckEqual("Ladakh", nodes[0].Title, t)
+	testutils.CheckEqual("Ladakh article", nodes[0].RefTitle, t)
+	testutils.CheckNotNil(nodes[0].Embedding, t)
+
+	// An embedder returning too few embeddings fails the nodes instead of the ingest panicking.
+	ingestor, err = intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(shortEmbedder{fake}),
+		intellichunk.WithTokenizer(&words{}),
+		intellichunk.WithStrategy(intellichunk.StrategyRecursive),
+	)
+	testutils.CheckNotError(err, t)
+	_, err = ingestor.GenerateContainerNodes(resp, "Ladakh article", "https://example.com/ladakh")
+	testutils.CheckNotNil(err, t)
+	_, err = ingestor.AddWithoutNodes("Class_short", []models.GeneralDataHolder{{Title: "Ladakh", Content: "Ladakh borders Tibet."}})
+	testutils.CheckNotNil(err, t)
+
+	_, err = intellichunk.NewIngestor(intellichunk.WithFunctionModel(fake), intellichunk.WithEmbedder(fake), intellichunk.WithStrategy("unknown"))
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_SemanticChunker(t *testing.T) {
+	ctx := context.Background()
+	// The topic changes from the borders to tourism, the sentences of a topic share their words.
+	text := "Ladakh borders Tibet. Ladakh borders Tibet and China. Tourism grew in Leh. Tourism grew in Leh and Kargil."
+	semantic := &intellichunk.SemanticChunker{Tokenizer: &words{}, Embedder: llm.NewFake(), Percentile: 10, MinTokens: 2, MaxTokens: 20}
+	container, err := semantic.Chunk(ctx, text)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(2, len(container.Nodes), t)
+	testutils.CheckEqual("Ladakh borders Tibet. Ladakh borders Tibet and China.", container.Nodes[0].Content, t)
+	testutils.CheckEqual("Tourism grew in Leh. Tourism grew in Leh and Kargil.", container.Nodes[1].Content, t)
+
+	// Nodes shorter than MinTokens don't end at a topic change.
+	semantic.MinTokens = 10
+	container, err = semantic.Chunk(ctx, text)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(1, len(container.Nodes), t)
+
+	// Nodes end before MaxTokens, and sentences longer than it are split by words.
+	semantic.MinTokens, semantic.MaxTokens = 0, 5
+	container, err = semantic.Chunk(ctx, text)
+	testutils.CheckNotError(err, t)
+	for _, node := range container.Nodes {
+		testutils.CheckTrue(len(strings.Fields(node.Content)) <= 5, t)
+	}
+	testutils.CheckEqual("Ladakh borders Tibet.", container.Nodes[0].Content, t)
+
+	semantic.MinTokens = 6
+	_, err = semantic.Chunk(ctx, text)
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_NodeMetadata(t *testing.T) {
+	fake := llm.NewFake().AddFunctionResponses(
+		`{"title": "Ladakh borders", "summary": "Ladakh borders Tibet.", "abstract_description": "A region.", "nodes": [
+			{"sectionNumber": 1, "keywords": ["Tibet"], "questions": ["What borders Ladakh?"]}]}`,
+	)
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithTokenizer(&words{}),
+		intellichunk.WithStrategy(intellichunk.StrategySemantic),
+		intellichunk.WithNodeMetadata(true),
+	)
+	testutils.CheckNotError(err, t)
+
+	text := "Ladakh is bordered by Tibet to the east."
+	resp, err := ingestor.SplitTextIntoContainerNodes(text)
+	testutils.CheckNotError(err, t)
+	calls := fake.Calls()
+	testutils.CheckEqual(1, len(calls), t)
+	testutils.CheckEqual("describe_sections", calls[0].Function, t)
+	testutils.CheckTrue(strings.Contains(calls[0].SystemMessage, "[1] Ladakh is bordered by Tibet to the east."), t)
+
+	// The model describes the nodes without rewriting them.
+	var container models.DataContainer
+	testutils.CheckNotError(json.Unmarshal([]byte(resp), &container), t)
+	testutils.CheckEqual("Ladakh borders", container.Title, t)
+	testutils.CheckEqual(1, len(container.Nodes), t)
+	testutils.CheckEqual(text, container.Nodes[0].Content, t)
+	testutils.CheckEqual([]string{"Tibet"}, container.Nodes[0].Keywords, t)
+	testutils.CheckEqual([]string{"What borders Ladakh?"}, container.Nodes[0].Questions, t)
+
+	// The nodes are kept without metadata when the model fails.
+	resp, err = ingestor.SplitTextIntoContainerNodes(text)
+	testutils.CheckNotError(err, t)
+	container = models.DataContainer{}
+	testutils.CheckNotError(json.Unmarshal([]byte(resp), &container), t)
+	testutils.CheckEqual(text, container.Title, t)
+	testutils.CheckEqual(0, len(container.Nodes[0].Keywords), t)
+}
+
+func Test_Provenance(t *testing.T) {
+	fake := llm.NewFake()
+	store, err := vectorstore.NewMemoryStore()
+	testutils.CheckNotError(err, t)
+	ingestor, err := intellichunk.NewIngestor(
+		intellichunk.WithFunctionModel(fake),
+		intellichunk.WithEmbedder(fake),
+		intellichunk.WithStore(store),
+		intellichunk.WithChunker(&intellichunk.RecursiveChunker{Tokenizer: &words{}, Tokens: 5}),
+	)
+	testutils.CheckNotError(err, t)
+
+	// A byte order mark and accents, so the byte and character offsets differ.
+	file := "\ufeffTitle: Lé Ladakh\nRefURL:https://example.com/ladakh\nContent: Ladakh is bordered by Tibet.\n\nThe café serves butter tea.\n" +
+		"Title: Leh\nRefURL:https://example.com/leh\nContent:\n  Leh is the capital."
+	folder := t.TempDir()
+	path := filepath.Join(folder, "ladakh.txt")
+	testutils.CheckNotError(os.WriteFile(path, []byte(file), 0o600), t)
+
+	objIDs, err := ingestor.AddFromFolder("Class_provenance", folder, false)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(3, len(objIDs), t)
+
+	objects, err := store.GetObjects("Class_provenance", []string{
+		"content", "source_path", "article_index", "start_offset", "end_offset", "start_char", "end_char", "content_hash", "ingested_at",
+	}, 10)
+	testutils.CheckNotError(err, t)
+	articles := []float64{0, 0, 1}
+	for n, object := range objects.([]map[string]interface{}) {
+		content := object["content"].(string)
+		start, end := int(object["start_offset"].(float64)), int(object["end_offset"].(float64))
+		startChar, endChar := int(object["start_char"].(float64)), int(object["end_char"].(float64))
+		testutils.CheckEqual(content, file[start:end], t)
+		testutils.CheckEqual(content, string([]rune(file)[startChar:endChar]), t)
+		testutils.CheckEqual(path, object["source_path"], t)
+		testutils.CheckEqual(articles[n], object["article_index"], t)
+		hash := sha256.Sum256([]byte(content))
+		testutils.CheckEqual(hex.EncodeToString(hash[:]), object["content_hash"], t)
+		testutils.CheckTrue(object["ingested_at"] != "", t)
+	}
+
+	// The nodes split by the model are located where they align with the text.
+	ingestor, err = intellichunk.NewIngestor(intellichunk.WithFunctionModel(fake), intellichunk.WithEmbedder(fake), intellichunk.WithStore(store))
+	testutils.CheckNotError(err, t)
+	fake.AddFunctionResponses(`{"title": "Ladakh", "summary": "", "abstract_description": "", "nodes": [
+		{"content": "Ladakh is bordered by Tibet.", "sectionNumber": 1},
+		{"content": "Since 1974 the Government of India has encouraged tourism in Ladakh", "sectionNumber": 2}]}`)
+	text := "Ladakh is bordered by Tibet. Since 1974, the Government of India has encouraged tourism in Ladakh."
+	resp, err := ingestor.SplitTextIntoContainerNodes(text)
+	testutils.CheckNotError(err, t)
+	var container models.DataContainer
+	testutils.CheckNotError(json.Unmarshal([]byte(resp), &container), t)
+	testutils.CheckEqual(2, len(container.Nodes), t)
+	testutils.CheckEqual(models.Offsets{StartOffset: 0, EndOffset: 28, StartChar: 0, EndChar: 28}, *container.Nodes[0].Offsets, t)
+	testutils.CheckEqual("Since 1974, the Government of India has encouraged tourism in Ladakh.", text[container.Nodes[1].Offsets.StartOffset:container.Nodes[1].Offsets.EndOffset], t)
+}
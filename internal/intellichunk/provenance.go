@@ -0,0 +1,71 @@
+package intellichunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+// Source locates a text in the file it was read from, for the provenance of its nodes.
+// The zero Source is a text added on its own, the offsets of its nodes are then in the text.
+type Source struct {
+	// Path is the file the text was read from.
+	Path string
+	// Article is the index of the text among the articles of the file.
+	Article int
+	// Offset and CharOffset are the byte and character offsets of the text in the file.
+	Offset     int
+	CharOffset int
+}
+
+// locate sets the offsets of the nodes in the source, where their content is found verbatim after the previous node,
+// or else where the report aligns it. The report is computed only when a node isn't verbatim, nodes that aren't
+// from the source keep no offsets.
+func locate(source string, nodes []models.ContainerNode, report *FidelityReport) {
+	from := 0
+	for n := range nodes {
+		node := &nodes[n]
+		node.Offsets = nil
+		start, end := -1, -1
+		if at := strings.Index(source[from:], node.Content); node.Content != "" && at >= 0 {
+			start, end = from+at, from+at+len(node.Content)
+		} else {
+			if report == nil {
+				verified := VerifyFidelity(source, nodes)
+				report = &verified
+			}
+			if alignment := report.Nodes[n]; alignment.Match >= _minRepairMatch && alignment.End > alignment.Start {
+				start, end = alignment.Start, alignment.End
+			}
+		}
+		if start < 0 {
+			continue
+		}
+
+		startChar := utf8.RuneCountInString(source[:start])
+		node.Offsets = &models.Offsets{
+			StartOffset: start,
+			EndOffset:   end,
+			StartChar:   startChar,
+			EndChar:     startChar + utf8.RuneCountInString(source[start:end]),
+		}
+		// Nodes may overlap, e.g. the ones of StrategyToken, so the next one is searched from the start of this one.
+		from = start
+	}
+}
+
+// provenance sets the source of the node and its offsets in the file, from its offsets in the text.
+func provenance(node *models.ContainerNodeVector, offsets *models.Offsets, source Source) {
+	node.SourcePath = source.Path
+	node.ArticleIndex = source.Article
+	if offsets != nil {
+		startOffset, endOffset := source.Offset+offsets.StartOffset, source.Offset+offsets.EndOffset
+		startChar, endChar := source.CharOffset+offsets.StartChar, source.CharOffset+offsets.EndChar
+		node.StartOffset, node.EndOffset, node.StartChar, node.EndChar = &startOffset, &endOffset, &startChar, &endChar
+	}
+	hash := sha256.Sum256([]byte(node.Content))
+	node.ContentHash = hex.EncodeToString(hash[:])
+}
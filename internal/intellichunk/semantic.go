@@ -0,0 +1,119 @@
+package intellichunk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// StrategySemantic splits the text where the topic changes, by the similarity of the embeddings of adjacent sentences.
+const StrategySemantic = "semantic"
+
+const (
+	// DefaultSemanticPercentile is the percentile of the similarities of adjacent sentences below which a node ends.
+	DefaultSemanticPercentile = 10
+	// DefaultSemanticMinTokens is the size in tokens a node must reach before it ends at a topic change.
+	DefaultSemanticMinTokens = 64
+)
+
+// SemanticChunker embeds the sentences of the texts and ends the nodes where the similarity of adjacent sentences
+// drops below the Percentile of their similarities, so every node keeps to a topic. The nodes are at least MinTokens
+// long, unless the text is shorter, and at most MaxTokens, sentences longer than that are split by words.
+type SemanticChunker struct {
+	Tokenizer  TextTokenizer
+	Embedder   llm.Embedder
+	Percentile float64
+	MinTokens  int
+	MaxTokens  int
+}
+
+// Chunk splits the text by topics.
+func (c *SemanticChunker) Chunk(ctx context.Context, text string) (models.DataContainer, error) {
+	if c.MinTokens < 0 || c.MaxTokens <= 0 || c.MinTokens > c.MaxTokens {
+		return models.DataContainer{}, fmt.Errorf("invalid chunks of %d to %d tokens", c.MinTokens, c.MaxTokens)
+	}
+	if c.Percentile < 0 || c.Percentile > 100 {
+		return models.DataContainer{}, fmt.Errorf("percentile must be between 0 and 100, got %v", c.Percentile)
+	}
+	counter := newTokenCounter(c.Tokenizer)
+
+	sentences := splitSentences(text)
+	if len(sentences) == 0 {
+		return container(titleOf(text), nil, nil), nil
+	}
+	embeddings, err := c.Embedder.GenerateMultipleEmbeddingsFromText(ctx, sentences)
+	if err != nil {
+		return models.DataContainer{}, fmt.Errorf("failed to embed the sentences: %w", err)
+	}
+	if len(embeddings) != len(sentences) {
+		return models.DataContainer{}, fmt.Errorf("got %d embeddings for %d sentences", len(embeddings), len(sentences))
+	}
+
+	// similarities[n] is the similarity of the sentences n and n+1.
+	similarities := make([]float64, 0, len(sentences)-1)
+	for n := 1; n < len(sentences); n++ {
+		distance := vectorstore.Cosine.Distance(embeddings[n-1], embeddings[n])
+		similarities = append(similarities, float64(vectorstore.Cosine.Similarity(distance)))
+	}
+	threshold := percentile(similarities, c.Percentile)
+
+	words := &RecursiveChunker{Tokenizer: c.Tokenizer, Tokens: c.MaxTokens}
+	var chunks []string
+	var current strings.Builder
+	currentTokens := 0
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentTokens = 0
+		}
+	}
+	for n, sentence := range sentences {
+		tokens := counter.count(sentence)
+		if tokens > c.MaxTokens {
+			flush()
+			chunks = append(chunks, words.split(counter, sentence)...)
+			continue
+		}
+		topicChange := n > 0 && similarities[n-1] < threshold && currentTokens >= c.MinTokens
+		if topicChange || currentTokens+tokens > c.MaxTokens {
+			flush()
+		}
+		current.WriteString(sentence)
+		currentTokens += tokens
+	}
+	flush()
+	return container(titleOf(text), chunks, nil), nil
+}
+
+// splitSentences returns the sentences of the text with the spaces following them, paragraphs end their last sentence.
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, paragraph := range splitAfter(text, _separators[0]) {
+		for _, sentence := range splitAfter(paragraph, _separators[2]) {
+			if strings.TrimSpace(sentence) != "" {
+				sentences = append(sentences, sentence)
+			}
+		}
+	}
+	return sentences
+}
+
+// percentile returns the p-th percentile of the values, interpolated between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.Inf(-1)
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	low := int(math.Floor(rank))
+	high := int(math.Ceil(rank))
+	return sorted[low] + (rank-float64(low))*(sorted[high]-sorted[low])
+}
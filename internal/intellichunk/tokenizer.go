@@ -3,6 +3,7 @@ package intellichunk
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	tiktoken "github.com/pkoukk/tiktoken-go"
 )
@@ -11,12 +12,16 @@ const (
 	_defaultTokenEncoding = "cl100k_base"
 )
 
+// encodings caches the loaded encodings by name, loading one parses its whole BPE file.
+var encodings sync.Map
+
 // Tokenizer is a structure that contains the encoding information.
 type Tokenizer struct {
 	EncodingName string
 }
 
 // NewTokenizer initializes a new tokenizer with the encoding provided, if no encoding is provided default is used.
+// The encoding can also be a model name, e.g. "gpt-4", models tiktoken doesn't know use the default encoding.
 func NewTokenizer(encodingName ...string) Tokenizer {
 	encoding := _defaultTokenEncoding
 	if len(encodingName) > 0 {
@@ -37,16 +42,9 @@ func (t Tokenizer) CountTokens(text string) (int, error) {
 
 // Tokenize text into tokens using Tiktoken.
 func (t Tokenizer) Tokenize(text string) ([]int, error) {
-	// Setting the cache directory
-	err := os.Setenv("TIKTOKEN_CACHE_DIR", "cache/")
-	if err != nil {
-		return nil, fmt.Errorf("error setting environment variable: %w", err)
-	}
-
-	// Get the encoding
-	tk, err := tiktoken.EncodingForModel(t.EncodingName)
+	tk, err := t.encoding()
 	if err != nil {
-		return nil, fmt.Errorf("tiktoken.EncodingForModel: %w", err)
+		return nil, err
 	}
 
 	// Tokenize the text
@@ -61,3 +59,40 @@ func (t Tokenizer) Tokenize(text string) ([]int, error) {
 
 	return tokens, nil
 }
+
+// Decode turns tokens back into text.
+func (t Tokenizer) Decode(tokens []int) (string, error) {
+	tk, err := t.encoding()
+	if err != nil {
+		return "", err
+	}
+	return tk.Decode(tokens), nil
+}
+
+// encoding returns the encoding of the model or encoding named EncodingName.
+func (t Tokenizer) encoding() (*tiktoken.Tiktoken, error) {
+	if tk, ok := encodings.Load(t.EncodingName); ok {
+		return tk.(*tiktoken.Tiktoken), nil
+	}
+
+	// Setting the cache directory
+	err := os.Setenv("TIKTOKEN_CACHE_DIR", "cache/")
+	if err != nil {
+		return nil, fmt.Errorf("error setting environment variable: %w", err)
+	}
+
+	// Get the encoding of the model, or the encoding itself, or the default one for unknown models.
+	tk, err := tiktoken.EncodingForModel(t.EncodingName)
+	if err != nil {
+		tk, err = tiktoken.GetEncoding(t.EncodingName)
+	}
+	if err != nil {
+		tk, err = tiktoken.GetEncoding(_defaultTokenEncoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tiktoken.GetEncoding: %w", err)
+	}
+
+	encodings.Store(t.EncodingName, tk)
+	return tk, nil
+}
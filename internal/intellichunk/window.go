@@ -0,0 +1,273 @@
+package intellichunk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+const (
+	// DefaultWindowTokens is the size of the windows longer texts are split in, the function model writes the text
+	// of its window back as nodes so the window and the answer must fit in the context of gpt-3.5-turbo.
+	DefaultWindowTokens = 1500
+	// DefaultWindowOverlap is the number of tokens a window repeats from the end of the previous one,
+	// so the sentences cut at the end of a window are whole in the next one.
+	DefaultWindowOverlap = 150
+)
+
+// _charsPerToken estimates the number of tokens of texts the tokenizer fails on.
+const _charsPerToken = 4
+
+// _duplicateOverlap is the share of the shorter of two nodes of consecutive windows their spans of the text share
+// beyond which both are the same section split twice from the overlap.
+const _duplicateOverlap = 0.5
+
+// TextTokenizer turns texts into tokens and back, Tokenizer does it with the tiktoken encodings.
+type TextTokenizer interface {
+	Tokenize(text string) ([]int, error)
+	Decode(tokens []int) (string, error)
+}
+
+// WithTokenizer sets the tokenizer cutting the long texts into windows.
+func WithTokenizer(tokenizer TextTokenizer) Option {
+	return func(i *Ingestor) {
+		i.tokenizer = tokenizer
+	}
+}
+
+// WithWindow sets the size in tokens of the windows long texts are split in, and how many tokens of the previous window
+// each one repeats. DefaultWindowTokens and DefaultWindowOverlap by default.
+func WithWindow(tokens, overlap int) Option {
+	return func(i *Ingestor) {
+		i.windowTokens = tokens
+		i.windowOverlap = overlap
+	}
+}
+
+// window is a part of a long text split on its own by the function model.
+type window struct {
+	text string
+	// offsets are the ones of the window in the text, nil when the tokenizer doesn't decode the text verbatim.
+	offsets *models.Offsets
+}
+
+// windows cuts the text into overlapping windows of at most windowTokens tokens, texts that fit are a single window.
+func (i *Ingestor) windows(text string) []window {
+	texts := tokenWindows(i.tokenizer, text, i.windowTokens, i.windowOverlap)
+	nodes := make([]models.ContainerNode, len(texts))
+	for n, windowText := range texts {
+		nodes[n].Content = windowText
+	}
+	locate(text, nodes, nil)
+
+	windows := make([]window, len(texts))
+	for n, node := range nodes {
+		windows[n] = window{text: node.Content, offsets: node.Offsets}
+	}
+	return windows
+}
+
+// locateInWindow sets the offsets in the whole text of the nodes split from the window, they are nil when the window
+// or the node can't be located.
+func locateInWindow(w window, nodes []models.ContainerNode) {
+	locate(w.text, nodes, nil)
+	for n := range nodes {
+		if w.offsets == nil {
+			nodes[n].Offsets = nil
+		}
+		if offsets := nodes[n].Offsets; offsets != nil {
+			offsets.StartOffset += w.offsets.StartOffset
+			offsets.EndOffset += w.offsets.StartOffset
+			offsets.StartChar += w.offsets.StartChar
+			offsets.EndChar += w.offsets.StartChar
+		}
+	}
+}
+
+// tokenWindows cuts the text into windows of at most size tokens, each repeating the last overlap tokens of the previous one.
+// The windows start and end at the tokens starting a character, tokens may hold a part of a multi-byte character.
+// The tokens are estimated from the length of the text when the tokenizer fails.
+func tokenWindows(tokenizer TextTokenizer, text string, size, overlap int) []string {
+	tokens, err := tokenizer.Tokenize(text)
+	if err != nil {
+		log.Warningf("Failed to tokenize the text, estimating its tokens: %v", err)
+		return runeWindows(text, size*_charsPerToken, overlap*_charsPerToken)
+	}
+	if len(tokens) <= size {
+		return []string{text}
+	}
+
+	startsRune := func(n int) bool {
+		token, err := tokenizer.Decode(tokens[n : n+1])
+		return err != nil || token == "" || utf8.RuneStart(token[0])
+	}
+	var windows []string
+	for start := 0; ; {
+		end := start + size
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		for end < len(tokens) && end > start+1 && !startsRune(end) {
+			end--
+		}
+		window, err := tokenizer.Decode(tokens[start:end])
+		if err != nil {
+			log.Warningf("Failed to decode the tokens, estimating them: %v", err)
+			return runeWindows(text, size*_charsPerToken, overlap*_charsPerToken)
+		}
+		windows = append(windows, window)
+		if end == len(tokens) {
+			return windows
+		}
+
+		next := end - overlap
+		for next > start+1 && !startsRune(next) {
+			next--
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+}
+
+// runeWindows cuts the text into overlapping windows of at most size runes, ending at a space when there is one.
+func runeWindows(text string, size, overlap int) []string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return []string{text}
+	}
+
+	var windows []string
+	for start := 0; ; {
+		end := start + size
+		if end >= len(runes) {
+			return append(windows, string(runes[start:]))
+		}
+		// Don't cut a word in two, unless the window is one word.
+		for cut := end; cut > start+overlap; cut-- {
+			if unicode.IsSpace(runes[cut]) {
+				end = cut
+				break
+			}
+		}
+		windows = append(windows, string(runes[start:end]))
+		start = end - overlap
+	}
+}
+
+// mergeWindows merges the containers split from consecutive windows into the container of the whole text.
+// The nodes split twice from the overlap of two windows are kept once, the longer one, and the sections are renumbered.
+// The nodes are located in the text by locateInWindow, the ones without offsets are always kept.
+func (i *Ingestor) mergeWindows(windows []window, containers []models.DataContainer) models.DataContainer {
+	var merged models.DataContainer
+	// The nodes of the previous window start at previous, the ones of the current window may repeat them.
+	previous := 0
+	for w, container := range containers {
+		current := len(merged.Nodes)
+		for _, node := range container.Nodes {
+			if w == 0 {
+				merged.Nodes = append(merged.Nodes, node)
+				continue
+			}
+			if n := duplicateNode(node, merged.Nodes[previous:current], windows[w-1], windows[w]); n >= 0 {
+				if len(node.Content) > len(merged.Nodes[previous+n].Content) {
+					merged.Nodes[previous+n] = node
+				}
+				continue
+			}
+			merged.Nodes = append(merged.Nodes, node)
+		}
+		previous = current
+	}
+	for n := range merged.Nodes {
+		merged.Nodes[n].NodeNumber = n + 1
+	}
+
+	merged.Title, merged.Summary, merged.AbstactSum = i.summarizeWindows(containers)
+	return merged
+}
+
+// duplicateNode returns the index of the node of the previous window node repeats, or -1. Only the nodes within
+// the overlap of the windows are compared, by the share of their spans of the text.
+func duplicateNode(node models.ContainerNode, nodes []models.ContainerNode, previous, current window) int {
+	if node.Offsets == nil || previous.offsets == nil || current.offsets == nil || node.Offsets.StartOffset >= previous.offsets.EndOffset {
+		return -1
+	}
+	for n, other := range nodes {
+		if other.Offsets == nil || other.Offsets.EndOffset <= current.offsets.StartOffset {
+			continue
+		}
+		start, end := node.Offsets.StartOffset, node.Offsets.EndOffset
+		if other.Offsets.StartOffset > start {
+			start = other.Offsets.StartOffset
+		}
+		if other.Offsets.EndOffset < end {
+			end = other.Offsets.EndOffset
+		}
+		shorter := node.Offsets.EndOffset - node.Offsets.StartOffset
+		if length := other.Offsets.EndOffset - other.Offsets.StartOffset; length < shorter {
+			shorter = length
+		}
+		if end > start && float64(end-start) >= _duplicateOverlap*float64(shorter) {
+			return n
+		}
+	}
+	return -1
+}
+
+// summarizeWindows asks the function model for the title, summary and abstract description of the whole text
+// from the ones of its windows. When it fails, the first title is kept and the summaries are joined.
+func (i *Ingestor) summarizeWindows(containers []models.DataContainer) (title, summary, abstract string) {
+	var parts strings.Builder
+	for n, container := range containers {
+		fmt.Fprintf(&parts, "Part %d\nTitle: %s\nSummary: %s\nDescription: %s\n\n", n+1, container.Title, container.Summary, container.AbstactSum)
+	}
+
+	funcDef := []models.FunctionDefinition{
+		{
+			Name:        "summarize_document",
+			Description: "Describes a whole document from the descriptions of its consecutive parts",
+			Parameters: models.Definition{
+				Type: models.Object,
+				Properties: map[string]models.Definition{
+					"title": {
+						Type:        models.String,
+						Description: "Title of the whole document",
+					},
+					"summary": {
+						Type:        models.String,
+						Description: "Single paragraph summary of the whole document.",
+					},
+					"abstract_description": {
+						Type:        models.String,
+						Description: "Very abstract description of the whole document",
+					},
+				},
+				Required: []string{"title", "summary", "abstract_description"},
+			},
+		},
+	}
+	prompt := "The following are the titles, summaries and descriptions of the consecutive parts of a single document. Write a title considering the unique entities of the document, a single paragraph summary and an abstract description of the whole document.\n\n" + parts.String()
+
+	resp, err := i.functionModel.ChatCompletionFunctionsOptions(context.Background(), prompt, funcDef)
+	var document models.DataContainer
+	if err == nil {
+		err = json.Unmarshal([]byte(resp), &document)
+	}
+	if err != nil || document.Title == "" {
+		log.Warningf("Failed to summarize the document, keeping the summaries of its parts: %v", err)
+		summaries := make([]string, 0, len(containers))
+		for _, container := range containers {
+			summaries = append(summaries, container.Summary)
+		}
+		return containers[0].Title, strings.Join(summaries, " "), containers[0].AbstactSum
+	}
+	return document.Title, document.Summary, document.AbstactSum
+}
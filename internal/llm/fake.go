@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+const _defaultFakeDimensions = 64
+
+// _fakeDelta matches the deltas the fake streams, a word with the spaces before it or the trailing spaces.
+var _fakeDelta = regexp.MustCompile(`\s*\S+|\s+$`)
+
+// ErrNoScriptedResponse is returned by Fake when it runs out of scripted responses.
+var ErrNoScriptedResponse = errors.New("fake llm: no scripted response left")
+
+// FakeCall records a request made to Fake.
+type FakeCall struct {
+	SystemMessage string
+	UserMessage   string
+	ChatHistory   []string
+	Function      string
+}
+
+// Fake is a deterministic LanguageModel for hermetic tests.
+// Chat and function calls return the scripted responses in order, then fall back to ChatFunc and FunctionFunc.
+// Embeddings are a hashed bag of words, so identical texts get identical vectors and texts sharing words are close.
+type Fake struct {
+	// Dimensions of the generated embeddings.
+	Dimensions int
+	// ChatFunc answers chat completions once the scripted responses are used up.
+	ChatFunc func(systemMessage, userMessage string, chatHistory []string) (string, error)
+	// FunctionFunc answers function calls once the scripted responses are used up.
+	FunctionFunc func(systemMessage string, funcDetails []models.FunctionDefinition) (string, error)
+
+	mu                sync.Mutex
+	chatResponses     []string
+	functionResponses []string
+	calls             []FakeCall
+}
+
+var (
+	_ LanguageModel      = (*Fake)(nil)
+	_ StreamingChatModel = (*Fake)(nil)
+)
+
+// NewFake creates a Fake without any scripted responses.
+func NewFake() *Fake {
+	return &Fake{Dimensions: _defaultFakeDimensions}
+}
+
+// AddChatResponses queues responses returned by ChatCompletion and ChatCompletionWithInstructions.
+func (f *Fake) AddChatResponses(responses ...string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chatResponses = append(f.chatResponses, responses...)
+	return f
+}
+
+// AddFunctionResponses queues JSON arguments returned by ChatCompletionFunctionsOptions.
+func (f *Fake) AddFunctionResponses(responses ...string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.functionResponses = append(f.functionResponses, responses...)
+	return f
+}
+
+// Calls returns the chat and function requests received so far.
+func (f *Fake) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// ChatCompletion returns the next scripted chat response.
+func (f *Fake) ChatCompletion(ctx context.Context, userMessage string) (string, error) {
+	return f.ChatCompletionWithInstructions(ctx, "", userMessage, nil)
+}
+
+// ChatCompletionWithInstructions returns the next scripted chat response.
+func (f *Fake) ChatCompletionWithInstructions(ctx context.Context, systemMessage, userMessage string, chatHistory []string) (string, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{SystemMessage: systemMessage, UserMessage: userMessage, ChatHistory: chatHistory})
+	if len(f.chatResponses) > 0 {
+		resp := f.chatResponses[0]
+		f.chatResponses = f.chatResponses[1:]
+		f.mu.Unlock()
+		return resp, nil
+	}
+	f.mu.Unlock()
+
+	if f.ChatFunc != nil {
+		return f.ChatFunc(systemMessage, userMessage, chatHistory)
+	}
+	return "", ErrNoScriptedResponse
+}
+
+// ChatCompletionStream returns the next scripted chat response, streamed a word at a time.
+func (f *Fake) ChatCompletionStream(ctx context.Context, systemMessage, userMessage string, chatHistory []string, onDelta func(delta string) error) (string, error) {
+	resp, err := f.ChatCompletionWithInstructions(ctx, systemMessage, userMessage, chatHistory)
+	if err != nil {
+		return "", err
+	}
+	// Every delta is a word with the spaces before it, as models stream them.
+	streamed := 0
+	for _, delta := range _fakeDelta.FindAllString(resp, -1) {
+		streamed += len(delta)
+		if err := onDelta(delta); err != nil {
+			return resp[:streamed], err
+		}
+	}
+	return resp, nil
+}
+
+// ChatCompletionFunctionsOptions returns the next scripted function call arguments.
+func (f *Fake) ChatCompletionFunctionsOptions(ctx context.Context, systemMessage string, funcDetails []models.FunctionDefinition, opts ...LLMOption) (string, error) {
+	options := &LLMOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var function string
+	if len(funcDetails) > 0 {
+		function = funcDetails[0].Name
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{SystemMessage: systemMessage, ChatHistory: options.ChatHistory, Function: function})
+	if len(f.functionResponses) > 0 {
+		resp := f.functionResponses[0]
+		f.functionResponses = f.functionResponses[1:]
+		f.mu.Unlock()
+		return resp, nil
+	}
+	f.mu.Unlock()
+
+	if f.FunctionFunc != nil {
+		return f.FunctionFunc(systemMessage, funcDetails)
+	}
+	return "", ErrNoScriptedResponse
+}
+
+// GenerateMultipleEmbeddingsFromText returns a normalized hashed bag of words vector for every text.
+func (f *Fake) GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error) {
+	dimensions := f.Dimensions
+	if dimensions <= 0 {
+		dimensions = _defaultFakeDimensions
+	}
+
+	embedBatch := make([][]float32, 0, len(multipleText))
+	for _, text := range multipleText {
+		embedBatch = append(embedBatch, hashEmbedding(text, dimensions))
+	}
+	return embedBatch, nil
+}
+
+// hashEmbedding adds a signed one-hot vector per lower cased word and normalizes the sum.
+func hashEmbedding(text string, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		sign := float32(1)
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vector[sum%uint64(dimensions)] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		// Empty texts still get a valid unit vector.
+		vector[0] = 1
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 
+	"github.com/cckalen/intellichunk/internal/models"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -12,20 +13,52 @@ type API interface {
 	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (res openai.EmbeddingResponse, err error)
 }
 
-// LanguageModel interface.
-type LanguageModel interface {
-	ChatCompletion(ctx context.Context, userMessage string, chatHistory []string) (string, error)
-	GenerateEmbeddings(ctx context.Context, tokens []int, model openai.EmbeddingModel, user string) (openai.EmbeddingResponse, error)
-	GenerateMultipleEmbeddingsFromTokens(ctx context.Context, multipleTokens [][]int, model openai.EmbeddingModel, user string) (openai.EmbeddingResponse, error)
+// StreamAPI is implemented by the clients that can stream chat completions, such as the openai client.
+type StreamAPI interface {
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+}
+
+// ChatModel is a provider that can answer chat messages.
+// Even indexed chatHistory strings are the user’s input; and the odd index strings are the llm response.
+type ChatModel interface {
+	ChatCompletion(ctx context.Context, userMessage string) (string, error)
+	ChatCompletionWithInstructions(ctx context.Context, systemMessage, userMessage string, chatHistory []string) (string, error)
+}
+
+// StreamingChatModel is a ChatModel that can send its answer while it is generated.
+// onDelta is called with every piece of the answer in order, returning an error stops the stream.
+// The whole answer is returned once the stream ends.
+type StreamingChatModel interface {
+	ChatModel
+	ChatCompletionStream(ctx context.Context, systemMessage, userMessage string, chatHistory []string, onDelta func(delta string) error) (string, error)
+}
+
+// FunctionModel is a provider that can return structured output following a function definition.
+// The returned string is the JSON encoded arguments of the function call.
+type FunctionModel interface {
+	ChatCompletionFunctionsOptions(ctx context.Context, systemMessage string, funcDetails []models.FunctionDefinition, opts ...LLMOption) (string, error)
+}
+
+// Embedder is a provider that can turn texts into embedding vectors.
+type Embedder interface {
 	GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error)
 }
 
+// LanguageModel interface is implemented by every registered provider.
+type LanguageModel interface {
+	ChatModel
+	FunctionModel
+	Embedder
+}
+
 type LLMOptions struct {
-	APIKey      string
-	ModelName   string
-	ChatHistory []string
-	Temperature float32
-	TopP        float32
+	APIKey             string
+	BaseURL            string
+	ModelName          string
+	EmbeddingModelName string
+	ChatHistory        []string
+	Temperature        float32
+	TopP               float32
 }
 
 type LLMOption func(*LLMOptions)
@@ -44,6 +77,20 @@ func WithModelName(modelName string) LLMOption {
 	}
 }
 
+// WithBaseURL sets the base URL of an OpenAI compatible API, e.g. "http://localhost:11434/v1" for Ollama.
+func WithBaseURL(baseURL string) LLMOption {
+	return func(o *LLMOptions) {
+		o.BaseURL = baseURL
+	}
+}
+
+// WithEmbeddingModelName sets the model used to generate embeddings by providers that accept any model name.
+func WithEmbeddingModelName(modelName string) LLMOption {
+	return func(o *LLMOptions) {
+		o.EmbeddingModelName = modelName
+	}
+}
+
 func WithChatHistory(chatHistory []string) LLMOption {
 	return func(o *LLMOptions) {
 		o.ChatHistory = chatHistory
@@ -4,6 +4,12 @@ package llm_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/apsystole/log"
@@ -13,6 +19,7 @@ import (
 
 	"github.com/cckalen/intellichunk/config"
 	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
 )
 
 func init() {
@@ -62,8 +69,8 @@ func TestLanguageModelCompletion(t *testing.T) {
 	mockClient := new(MockClient)
 	mockClient.On("CreateChatCompletion", ctx, mock.Anything).Return(expectedResponse, nil)
 
-	// Create a new instance of OpenAI
-	lm := llm.NewOpenAI()
+	// Create a new instance of OpenAI sending its requests to the mock.
+	lm := llm.NewOpenAIWithClient(mockClient)
 
 	response, err := lm.ChatCompletion(ctx, userMessage)
 
@@ -89,8 +96,8 @@ func TestGenerateEmbeddings(t *testing.T) {
 	mockClient := new(MockClient)
 	mockClient.On("CreateEmbeddings", ctx, mock.Anything).Return(expectedResponse, nil)
 
-	// Create a new instance of OpenAI
-	openaiClient := llm.NewOpenAI()
+	// Create a new instance of OpenAI sending its requests to the mock.
+	openaiClient := llm.NewOpenAIWithClient(mockClient)
 
 	response, err := openaiClient.GenerateEmbeddings(ctx, tokens, model, user)
 
@@ -119,8 +126,8 @@ func TestGenerateMultipleEmbeddings(t *testing.T) {
 	mockClient := new(MockClient)
 	mockClient.On("CreateEmbeddings", ctx, mock.Anything).Return(expectedResponse, nil)
 
-	// Create a new instance of OpenAI
-	openaiClient := llm.NewOpenAI()
+	// Create a new instance of OpenAI sending its requests to the mock.
+	openaiClient := llm.NewOpenAIWithClient(mockClient)
 
 	response, err := openaiClient.GenerateMultipleEmbeddingsFromTokens(ctx, multipleTokens, model, user)
 
@@ -128,3 +135,263 @@ func TestGenerateMultipleEmbeddings(t *testing.T) {
 	testutils.CheckEqual(expectedResponse, response, t)
 	mockClient.AssertExpectations(t)
 }
+
+// batchClient embeds every text into its length, recording the number of texts of every request.
+type batchClient struct {
+	MockClient
+	batches []int
+	// dropLast leaves the embedding of the last text of every request out.
+	dropLast bool
+}
+
+func (c *batchClient) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	request := conv.(openai.EmbeddingRequestStrings)
+	c.batches = append(c.batches, len(request.Input))
+	var response openai.EmbeddingResponse
+	for i, text := range request.Input {
+		response.Data = append(response.Data, openai.Embedding{Index: i, Embedding: []float32{float32(len(text))}})
+	}
+	if c.dropLast {
+		response.Data = response.Data[:len(response.Data)-1]
+	}
+	return response, nil
+}
+
+// TestGenerateMultipleEmbeddingsBatches checks that more texts than a request of the embeddings API takes are sent in batches.
+func TestGenerateMultipleEmbeddingsBatches(t *testing.T) {
+	texts := make([]string, 2050)
+	for i := range texts {
+		texts[i] = strings.Repeat("a", i%7+1)
+	}
+	client := &batchClient{}
+
+	embeddings, err := llm.NewOpenAIWithClient(client).GenerateMultipleEmbeddingsFromText(context.Background(), texts)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual([]int{2048, 2}, client.batches, t)
+	testutils.CheckEqual(len(texts), len(embeddings), t)
+	for i, embedding := range embeddings {
+		testutils.CheckEqual(float32(len(texts[i])), embedding[0], t)
+	}
+
+	// A batch missing embeddings fails instead of shifting the embeddings of the next texts.
+	_, err = llm.NewOpenAIWithClient(&batchClient{dropLast: true}).GenerateMultipleEmbeddingsFromText(context.Background(), texts)
+	testutils.CheckNotNil(err, t)
+}
+
+// TestNewUnknownProvider checks that asking for a provider which isn't registered fails.
+func TestNewUnknownProvider(t *testing.T) {
+	_, err := llm.New("no-such-provider")
+	testutils.CheckNotNil(err, t)
+
+	// The fake is only for tests, it can't be selected in production.
+	_, err = llm.New("fake")
+	testutils.CheckNotNil(err, t)
+}
+
+// TestProviderSelectedByEnv checks that each role resolves its provider from the environment.
+func TestProviderSelectedByEnv(t *testing.T) {
+	registered := llm.NewOpenAI(llm.WithAPIKey("test"))
+	llm.Register("registry-test", func(opts ...llm.LLMOption) (llm.LanguageModel, error) {
+		return registered, nil
+	})
+	testutils.CheckTrue(contains(llm.Providers(), "openai"), t)
+	testutils.CheckTrue(contains(llm.Providers(), "registry-test"), t)
+
+	t.Setenv(llm.ProviderEnv, "no-such-provider")
+	t.Setenv(llm.EmbeddingProviderEnv, "registry-test")
+
+	embedder, err := llm.NewEmbedder()
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(llm.Embedder(registered), embedder, t)
+
+	// chat falls back to LLM_PROVIDER which is not registered.
+	_, err = llm.NewChatModel()
+	testutils.CheckNotNil(err, t)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newLocalServer starts a fake OpenAI compatible server answering chat completions with chatContent
+// and embeddings with one small vector per input.
+func newLocalServer(t *testing.T, chatContent string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: chatContent}}},
+		})
+	})
+	mux.HandleFunc("/v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "nomic-embed-text" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := make([]map[string]interface{}, len(req.Input))
+		// Return the embeddings in reverse order to check they're sorted by index.
+		for i := range req.Input {
+			j := len(req.Input) - 1 - i
+			data[i] = map[string]interface{}{"index": j, "embedding": []float32{float32(j), 1}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestLocalFunctionCall checks that the local provider extracts the JSON object from a fenced model answer.
+func TestLocalFunctionCall(t *testing.T) {
+	server := newLocalServer(t, "Sure!\n```json\n{\"title\": \"Ladakh\"}\n```")
+	lm := llm.NewLocal(llm.WithBaseURL(server.URL + "/v1"))
+
+	funcDef := []models.FunctionDefinition{{
+		Name: "split_into_sections",
+		Parameters: models.Definition{
+			Type:       models.Object,
+			Properties: map[string]models.Definition{"title": {Type: models.String}},
+		},
+	}}
+
+	resp, err := lm.ChatCompletionFunctionsOptions(context.Background(), "split this", funcDef)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(`{"title": "Ladakh"}`, resp, t)
+}
+
+// TestLocalEmbeddings checks that the local provider returns the embeddings in input order.
+func TestLocalEmbeddings(t *testing.T) {
+	server := newLocalServer(t, "")
+	lm := llm.NewLocal(llm.WithBaseURL(server.URL + "/v1"))
+
+	embeddings, err := lm.GenerateMultipleEmbeddingsFromText(context.Background(), []string{"a", "b", "c"})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(3, len(embeddings), t)
+	for i, embedding := range embeddings {
+		testutils.CheckEqual(float32(i), embedding[0], t)
+	}
+}
+
+// TestFakeScriptedResponses checks that the fake returns scripted responses in order and records the calls.
+func TestFakeScriptedResponses(t *testing.T) {
+	ctx := context.Background()
+	fake := llm.NewFake().AddChatResponses("first", "second")
+
+	resp, err := fake.ChatCompletionWithInstructions(ctx, "system", "hello", []string{"q", "a"})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("first", resp, t)
+
+	resp, err = fake.ChatCompletion(ctx, "again")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("second", resp, t)
+
+	_, err = fake.ChatCompletion(ctx, "one too many")
+	testutils.CheckEqual(llm.ErrNoScriptedResponse, err, t)
+
+	calls := fake.Calls()
+	testutils.CheckEqual(3, len(calls), t)
+	testutils.CheckEqual("system", calls[0].SystemMessage, t)
+	testutils.CheckEqual("hello", calls[0].UserMessage, t)
+}
+
+// TestFakeEmbeddings checks that fake embeddings are deterministic and closer for texts sharing words.
+func TestFakeEmbeddings(t *testing.T) {
+	fake := llm.NewFake()
+	embeddings, err := fake.GenerateMultipleEmbeddingsFromText(context.Background(),
+		[]string{"Ladakh borders Tibet", "Ladakh borders Tibet", "Ladakh tourism", "quantum chromodynamics"})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(embeddings[0], embeddings[1], t)
+	testutils.CheckNumericGreater(dot(embeddings[0], embeddings[3]), dot(embeddings[0], embeddings[2]), t)
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// TestOpenAIStream checks that the answer is streamed from the server sent events of a chat completion stream.
+func TestOpenAIStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{"Ladakh", " is", " in India."} {
+			data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: token}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	lm := llm.NewOpenAI(llm.WithAPIKey("test"), llm.WithBaseURL(server.URL))
+
+	var deltas []string
+	answer, err := lm.ChatCompletionStream(context.Background(), "system", "Where is Ladakh?", nil, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh is in India.", answer, t)
+	testutils.CheckEqual([]string{"Ladakh", " is", " in India."}, deltas, t)
+}
+
+// TestOpenAIStreamFallback checks that clients that can't stream answer in a single delta.
+func TestOpenAIStreamFallback(t *testing.T) {
+	mockClient := new(MockClient)
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "In India."}}},
+	}, nil)
+	lm := llm.NewOpenAIWithClient(mockClient)
+
+	var deltas []string
+	answer, err := lm.ChatCompletionStream(context.Background(), "system", "Where is Ladakh?", nil, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("In India.", answer, t)
+	testutils.CheckEqual([]string{"In India."}, deltas, t)
+}
+
+// TestFakeStream checks that the fake streams its scripted response a word at a time and stops when asked to.
+func TestFakeStream(t *testing.T) {
+	ctx := context.Background()
+	fake := llm.NewFake().AddChatResponses("Ladakh is  in India. ", "Stop here please")
+
+	var deltas []string
+	answer, err := fake.ChatCompletionStream(ctx, "system", "Where is Ladakh?", nil, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh is  in India. ", answer, t)
+	testutils.CheckEqual([]string{"Ladakh", " is", "  in", " India.", " "}, deltas, t)
+
+	stop := errors.New("stop")
+	answer, err = fake.ChatCompletionStream(ctx, "system", "Go on", nil, func(delta string) error {
+		if delta == " here" {
+			return stop
+		}
+		return nil
+	})
+	testutils.CheckEqual(stop, err, t)
+	testutils.CheckEqual("Stop here", answer, t)
+}
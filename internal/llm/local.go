@@ -0,0 +1,205 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cckalen/intellichunk/internal/models"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	_defaultLocalBaseURL        = "http://localhost:11434/v1"
+	_defaultLocalModel          = "llama3"
+	_defaultLocalEmbeddingModel = "nomic-embed-text"
+)
+
+// Local is a LanguageModel for a local OpenAI compatible server such as Ollama or the llama.cpp server.
+// Chat completions go through the OpenAI client pointed at the local base URL. Function calling is emulated
+// by asking the model for a JSON object matching the function schema, since most local models don't support it.
+type Local struct {
+	*OpenAI
+	httpClient *http.Client
+}
+
+var _ LanguageModel = (*Local)(nil)
+
+func init() {
+	local := func(opts ...LLMOption) (LanguageModel, error) {
+		return NewLocal(opts...), nil
+	}
+	Register("local", local)
+	Register("ollama", local)
+}
+
+// NewLocal creates a new Local instance. Defaults are read from LOCAL_LLM_BASE_URL, LOCAL_LLM_MODEL,
+// LOCAL_EMBEDDING_MODEL and LOCAL_LLM_API_KEY and can be overridden with options.
+func NewLocal(opts ...LLMOption) *Local {
+	defaults := []LLMOption{
+		WithBaseURL(envOrDefault("LOCAL_LLM_BASE_URL", _defaultLocalBaseURL)),
+		WithModelName(envOrDefault("LOCAL_LLM_MODEL", _defaultLocalModel)),
+		WithEmbeddingModelName(envOrDefault("LOCAL_EMBEDDING_MODEL", _defaultLocalEmbeddingModel)),
+		// Local servers ignore the key but the OpenAI client always sends one.
+		WithAPIKey(envOrDefault("LOCAL_LLM_API_KEY", "local")),
+	}
+
+	return &Local{
+		OpenAI:     NewOpenAI(append(defaults, opts...)...),
+		httpClient: http.DefaultClient,
+	}
+}
+
+// ChatCompletionFunctionsOptions asks the local model for a JSON object following the parameters of the first function
+// and returns it the same way OpenAI returns function call arguments.
+func (l *Local) ChatCompletionFunctionsOptions(ctx context.Context, systemMessage string, funcDetails []models.FunctionDefinition, opts ...LLMOption) (string, error) {
+	options := &LLMOptions{
+		ModelName: l.llmOptions.ModelName,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	funcDefs := ConvertToOpenAIFunctionDefinition(funcDetails)
+	if len(funcDefs) == 0 {
+		return "", fmt.Errorf("no valid function definition provided")
+	}
+
+	schema, err := json.Marshal(funcDefs[0].Parameters)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode function schema: %w", err)
+	}
+
+	instructions := fmt.Sprintf("%s\n\nRespond only with a single JSON object, without any explanation, "+
+		"holding the arguments of the function %q (%s). The JSON object must match this JSON schema: %s",
+		systemMessage, funcDefs[0].Name, funcDefs[0].Description, schema)
+
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: instructions,
+		},
+	}
+	messages = append(messages, historyMessages(options.ChatHistory)...)
+
+	resp, err := l.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Temperature: options.Temperature,
+			TopP:        options.TopP,
+			Model:       options.ModelName,
+			Messages:    messages,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create chat completion: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices in chat completion response")
+	}
+
+	return extractJSONObject(resp.Choices[0].Message.Content)
+}
+
+// localEmbeddingRequest is the body of an OpenAI compatible /embeddings request.
+// The openai package only accepts its own embedding models so the request is sent directly.
+type localEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type localEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// GenerateMultipleEmbeddingsFromText creates embeddings with the local embedding model,
+// in batches as for OpenAI since the compatible servers share its caps.
+func (l *Local) GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error) {
+	embedBatch := make([][]float32, 0, len(multipleText))
+	for _, batch := range embeddingBatches(multipleText) {
+		embeddings, err := l.embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		embedBatch = append(embedBatch, embeddings...)
+	}
+	return embedBatch, nil
+}
+
+// embed creates the embeddings of a batch of texts with a single request.
+func (l *Local) embed(ctx context.Context, multipleText []string) ([][]float32, error) {
+	body, err := json.Marshal(localEmbeddingRequest{
+		Model: l.llmOptions.EmbeddingModelName,
+		Input: multipleText,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embeddings request: %w", err)
+	}
+
+	url := strings.TrimSuffix(l.llmOptions.BaseURL, "/") + "/embeddings"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+l.llmOptions.APIKey)
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multiple embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to create multiple embeddings: status %s", resp.Status)
+	}
+
+	var embedResp localEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	if len(embedResp.Data) != len(multipleText) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(multipleText), len(embedResp.Data))
+	}
+
+	// Keep the embeddings in the same order as the input.
+	sort.Slice(embedResp.Data, func(i, j int) bool { return embedResp.Data[i].Index < embedResp.Data[j].Index })
+
+	embedBatch := make([][]float32, 0, len(embedResp.Data))
+	for _, em := range embedResp.Data {
+		embedBatch = append(embedBatch, em.Embedding)
+	}
+	return embedBatch, nil
+}
+
+// extractJSONObject returns the outermost JSON object found in a model response,
+// dropping any markdown code fences or text the model added around it.
+func extractJSONObject(content string) (string, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("no JSON object in response")
+	}
+
+	object := content[start : end+1]
+	if !json.Valid([]byte(object)) {
+		return "", fmt.Errorf("invalid JSON object in response")
+	}
+	return object, nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
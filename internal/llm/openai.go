@@ -2,8 +2,11 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/cckalen/intellichunk/internal/models"
 	openai "github.com/sashabaranov/go-openai"
@@ -15,8 +18,43 @@ type OpenAI struct {
 	llmOptions *LLMOptions
 }
 
-// NewOpenAI creates a new OpenAI instance with an optional API key.
+var (
+	_ LanguageModel      = (*OpenAI)(nil)
+	_ StreamingChatModel = (*OpenAI)(nil)
+)
+
+func init() {
+	Register("openai", func(opts ...LLMOption) (LanguageModel, error) {
+		return NewOpenAI(opts...), nil
+	})
+}
+
+// NewOpenAI creates a new OpenAI instance with an optional API key and base URL.
 func NewOpenAI(opts ...LLMOption) *OpenAI {
+	llmOptions := newOpenAIOptions(opts...)
+
+	config := openai.DefaultConfig(llmOptions.APIKey)
+	if llmOptions.BaseURL != "" {
+		config.BaseURL = llmOptions.BaseURL
+	}
+	client := openai.NewClientWithConfig(config)
+
+	return &OpenAI{
+		client:     client,
+		llmOptions: llmOptions,
+	}
+}
+
+// NewOpenAIWithClient creates a new OpenAI instance sending its requests to the given client, e.g. a mock in tests.
+func NewOpenAIWithClient(client API, opts ...LLMOption) *OpenAI {
+	return &OpenAI{
+		client:     client,
+		llmOptions: newOpenAIOptions(opts...),
+	}
+}
+
+// newOpenAIOptions applies the specified options over the defaults.
+func newOpenAIOptions(opts ...LLMOption) *LLMOptions {
 	defaultAPIKey := os.Getenv("OPENAI_API_KEY")
 	llmOptions := &LLMOptions{
 		APIKey:    defaultAPIKey,
@@ -27,13 +65,7 @@ func NewOpenAI(opts ...LLMOption) *OpenAI {
 	for _, opt := range opts {
 		opt(llmOptions)
 	}
-
-	client := openai.NewClient(llmOptions.APIKey)
-
-	return &OpenAI{
-		client:     client,
-		llmOptions: llmOptions,
-	}
+	return llmOptions
 }
 
 func ConvertToOpenAIFunctionDefinition(funcDefs []models.FunctionDefinition) []openai.FunctionDefinition {
@@ -79,6 +111,27 @@ func convertDefinitionToInterface(def models.Definition) interface{} {
 	return result
 }
 
+// historyMessages loops through chatHistory, assigning the role based on whether the index is even or odd.
+func historyMessages(chatHistory []string) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(chatHistory))
+	for i, message := range chatHistory {
+		role := openai.ChatMessageRoleUser
+		if i%2 != 0 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: message,
+		})
+	}
+	return messages
+}
+
+// ModelName returns the name of the model answering the chat completions.
+func (o *OpenAI) ModelName() string {
+	return o.llmOptions.ModelName
+}
+
 // ChatCompletion sends a chat completion request to the OpenAI API.
 func (o *OpenAI) ChatCompletion(ctx context.Context, userMessage string) (string, error) {
 	resp, err := o.client.CreateChatCompletion(
@@ -108,6 +161,70 @@ func (o *OpenAI) ChatCompletion(ctx context.Context, userMessage string) (string
 // ChatCompletionWithInstructions sends a chat completion request with two roles.
 // Even indexed strings are the user’s input;  and the odd index strings are the llm response
 func (o *OpenAI) ChatCompletionWithInstructions(ctx context.Context, systemMessage, userMessage string, chatHistory []string) (string, error) {
+	resp, err := o.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    o.llmOptions.ModelName,
+			Messages: instructionMessages(systemMessage, userMessage, chatHistory),
+		},
+	)
+
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices in chat completion response")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// ChatCompletionStream streams the answer of ChatCompletionWithInstructions, calling onDelta with every token received.
+// Clients that can't stream answer in a single delta.
+func (o *OpenAI) ChatCompletionStream(ctx context.Context, systemMessage, userMessage string, chatHistory []string, onDelta func(delta string) error) (string, error) {
+	client, ok := o.client.(StreamAPI)
+	if !ok {
+		answer, err := o.ChatCompletionWithInstructions(ctx, systemMessage, userMessage, chatHistory)
+		if err != nil {
+			return "", err
+		}
+		return answer, onDelta(answer)
+	}
+
+	stream, err := client.CreateChatCompletionStream(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    o.llmOptions.ModelName,
+			Messages: instructionMessages(systemMessage, userMessage, chatHistory),
+			Stream:   true,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create chat completion stream: %w", err)
+	}
+	defer stream.Close()
+
+	var answer strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return answer.String(), nil
+		}
+		if err != nil {
+			return answer.String(), fmt.Errorf("failed to receive chat completion stream: %w", err)
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := resp.Choices[0].Delta.Content
+		answer.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return answer.String(), err
+		}
+	}
+}
+
+// instructionMessages returns the system message, followed by the chat history and the user message.
+func instructionMessages(systemMessage, userMessage string, chatHistory []string) []openai.ChatCompletionMessage {
 	// Start with system message
 	messages := []openai.ChatCompletionMessage{
 		{
@@ -127,43 +244,19 @@ func (o *OpenAI) ChatCompletionWithInstructions(ctx context.Context, systemMessa
 			Content: histInfo,
 		})
 	}
-	// loop through chatHistory[], assigning the role based on whether the index is even or odd.
-	for i, message := range chatHistory {
-		role := openai.ChatMessageRoleUser
-		if i%2 != 0 {
-			role = openai.ChatMessageRoleAssistant
-		}
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    role,
-			Content: message,
-		})
-	}
+	messages = append(messages, historyMessages(chatHistory)...)
 
 	// Add current user message
-	messages = append(messages, openai.ChatCompletionMessage{
+	return append(messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: userMessage,
 	})
-
-	resp, err := o.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model:    o.llmOptions.ModelName,
-			Messages: messages,
-		},
-	)
-
-	if err != nil {
-		return "", err
-	}
-	// fmt.Println("=============================================================")
-	// fmt.Println(messages)
-	// fmt.Println("=============================================================")
-	return resp.Choices[0].Message.Content, nil
 }
 
 func (o *OpenAI) ChatCompletionFunctionsOptions(ctx context.Context, systemMessage string, funcDetails []models.FunctionDefinition, opts ...LLMOption) (string, error) {
-	options := &LLMOptions{}
+	options := &LLMOptions{
+		ModelName: o.llmOptions.ModelName,
+	}
 	for _, opt := range opts {
 		opt(options)
 	}
@@ -176,16 +269,7 @@ func (o *OpenAI) ChatCompletionFunctionsOptions(ctx context.Context, systemMessa
 	}
 
 	// Add chat history similar to ChatCompletionWithInstructions
-	for i, message := range options.ChatHistory {
-		role := openai.ChatMessageRoleUser
-		if i%2 != 0 {
-			role = openai.ChatMessageRoleAssistant
-		}
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    role,
-			Content: message,
-		})
-	}
+	messages = append(messages, historyMessages(options.ChatHistory)...)
 
 	resp, err := o.client.CreateChatCompletion(
 		ctx,
@@ -254,25 +338,51 @@ func (o *OpenAI) GenerateMultipleEmbeddingsFromTokens(ctx context.Context, multi
 	return response, nil
 }
 
-// GenerateMultipleEmbeddingsFromText creates embeddings request to the OpenAI API with multiple sets of tokens, an embedding model, and a user.
-func (o *OpenAI) GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error) {
-
-	response, err := o.client.CreateEmbeddings(
-		ctx,
-		openai.EmbeddingRequestStrings{
-			Input: multipleText,
-			Model: openai.AdaEmbeddingV2,
-			User:  "system",
-		},
-	)
-	embedBatch := make([][]float32, 0, len(response.Data))
+// The embeddings API caps the inputs of a request, and the tokens of all of them.
+const (
+	_maxEmbeddingInputs = 2048
+	// _maxEmbeddingChars keeps the tokens of a request well under the cap, at 3 characters a token for most texts.
+	_maxEmbeddingChars = 600000
+)
 
-	if err != nil {
-		return embedBatch, fmt.Errorf("failed to create multiple embeddings: %w", err)
+// embeddingBatches splits the texts into the batches of consecutive texts a request of the embeddings API takes.
+func embeddingBatches(texts []string) [][]string {
+	var batches [][]string
+	for start := 0; start < len(texts); {
+		end, chars := start, 0
+		for end < len(texts) && end-start < _maxEmbeddingInputs && (end == start || chars+len(texts[end]) <= _maxEmbeddingChars) {
+			chars += len(texts[end])
+			end++
+		}
+		batches = append(batches, texts[start:end])
+		start = end
 	}
+	return batches
+}
 
-	for _, em := range response.Data {
-		embedBatch = append(embedBatch, em.Embedding)
+// GenerateMultipleEmbeddingsFromText creates embeddings request to the OpenAI API with multiple sets of tokens, an embedding model, and a user.
+// The texts are sent in as many requests as the caps of the embeddings API need, the embeddings are in the order of the texts.
+func (o *OpenAI) GenerateMultipleEmbeddingsFromText(ctx context.Context, multipleText []string) ([][]float32, error) {
+	embedBatch := make([][]float32, 0, len(multipleText))
+	for _, batch := range embeddingBatches(multipleText) {
+		response, err := o.client.CreateEmbeddings(
+			ctx,
+			openai.EmbeddingRequestStrings{
+				Input: batch,
+				Model: openai.AdaEmbeddingV2,
+				User:  "system",
+			},
+		)
+		if err != nil {
+			return embedBatch, fmt.Errorf("failed to create multiple embeddings: %w", err)
+		}
+		if len(response.Data) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(response.Data))
+		}
+
+		for _, em := range response.Data {
+			embedBatch = append(embedBatch, em.Embedding)
+		}
 	}
 	return embedBatch, nil
 }
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Environment variables used to select a provider for each role.
+// LLM_PROVIDER is the fallback for every role that isn't set explicitly.
+const (
+	ProviderEnv          = "LLM_PROVIDER"
+	ChatProviderEnv      = "LLM_CHAT_PROVIDER"
+	FunctionProviderEnv  = "LLM_FUNCTION_PROVIDER"
+	EmbeddingProviderEnv = "LLM_EMBEDDING_PROVIDER"
+
+	// DefaultProvider is used when no provider is configured.
+	DefaultProvider = "openai"
+)
+
+// Provider builds a LanguageModel with the given options.
+type Provider func(opts ...LLMOption) (LanguageModel, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available by name. Registering the same name twice replaces the previous provider.
+func Register(name string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if provider == nil {
+		panic("llm: Register provider is nil")
+	}
+	providers[name] = provider
+}
+
+// Providers returns the sorted names of the registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a LanguageModel from the provider registered under name.
+func New(name string, opts ...LLMOption) (LanguageModel, error) {
+	providersMu.RLock()
+	provider, ok := providers[name]
+	providersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider %q (registered: %v)", name, Providers())
+	}
+	return provider(opts...)
+}
+
+// NewChatModel creates the chat provider configured by LLM_CHAT_PROVIDER.
+func NewChatModel(opts ...LLMOption) (ChatModel, error) {
+	return New(providerName(ChatProviderEnv), opts...)
+}
+
+// NewFunctionModel creates the function calling provider configured by LLM_FUNCTION_PROVIDER.
+func NewFunctionModel(opts ...LLMOption) (FunctionModel, error) {
+	return New(providerName(FunctionProviderEnv), opts...)
+}
+
+// NewEmbedder creates the embedding provider configured by LLM_EMBEDDING_PROVIDER.
+func NewEmbedder(opts ...LLMOption) (Embedder, error) {
+	return New(providerName(EmbeddingProviderEnv), opts...)
+}
+
+// providerName resolves the provider for a role, falling back to LLM_PROVIDER and then DefaultProvider.
+func providerName(roleEnv string) string {
+	if name := os.Getenv(roleEnv); name != "" {
+		return name
+	}
+	if name := os.Getenv(ProviderEnv); name != "" {
+		return name
+	}
+	return DefaultProvider
+}
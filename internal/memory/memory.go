@@ -0,0 +1,107 @@
+// Package memory keeps long conversations within reach of the chat model
+// by folding their older messages into a running summary written by the model.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/templateprompt"
+)
+
+const (
+	// DefaultThreshold is the number of history messages beyond which the older ones are summarized.
+	DefaultThreshold = 10
+	// DefaultKeep is the number of most recent history messages kept verbatim next to the summary.
+	DefaultKeep = 4
+)
+
+// Memory summarizes the older messages of a chat history once it grows past a threshold.
+// Even indexed history messages are the user's input and odd indexed ones the answers, as in llm.ChatModel.
+type Memory struct {
+	model     llm.ChatModel
+	threshold int
+	keep      int
+}
+
+// Option is a function that can modify the Memory configuration.
+type Option func(*Memory)
+
+// WithThreshold sets the number of history messages beyond which the older ones are summarized, DefaultThreshold by default.
+func WithThreshold(messages int) Option {
+	return func(m *Memory) {
+		m.threshold = messages
+	}
+}
+
+// WithKeep sets the number of most recent history messages kept verbatim, DefaultKeep by default.
+// It is rounded down to whole questions and answers.
+func WithKeep(messages int) Option {
+	return func(m *Memory) {
+		m.keep = messages
+	}
+}
+
+// New creates a Memory summarizing with model.
+func New(model llm.ChatModel, opts ...Option) *Memory {
+	m := &Memory{model: model, threshold: DefaultThreshold, keep: DefaultKeep}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.keep < 0 {
+		m.keep = 0
+	}
+	m.keep -= m.keep % 2
+	return m
+}
+
+// Condense folds the history beyond the threshold into the summary of the earlier conversation.
+// It returns the new summary, the recent messages still to send verbatim and the number of oldest messages summarized.
+// Histories within the threshold are returned as they are.
+func (m *Memory) Condense(ctx context.Context, summary string, history []string) (string, []string, int, error) {
+	if len(history) <= m.threshold || len(history) <= m.keep {
+		return summary, history, 0, nil
+	}
+
+	// Summarize whole questions and answers so the recent messages still start with a question.
+	summarized := len(history) - m.keep
+	summarized -= summarized % 2
+	if summarized == 0 {
+		return summary, history, 0, nil
+	}
+
+	tr, err := templateprompt.NewTemplateRenderer(templateprompt.SummarizeHistoryPrompt)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	prompt, err := tr.Render(map[string]string{
+		"Summary":  summary,
+		"Messages": Transcript(history[:summarized]),
+	})
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	newSummary, err := m.model.ChatCompletion(ctx, prompt)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("failed to summarize the chat history: %w", err)
+	}
+	return strings.TrimSpace(newSummary), history[summarized:], summarized, nil
+}
+
+// Transcript formats a chat history with the speaker of every message.
+func Transcript(history []string) string {
+	var b strings.Builder
+	for i, message := range history {
+		if i%2 == 0 {
+			b.WriteString("User: ")
+		} else {
+			b.WriteString("Assistant: ")
+		}
+		b.WriteString(message)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
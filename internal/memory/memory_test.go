@@ -0,0 +1,49 @@
+// Package memory_test is the test suite for the memory package.
+package memory_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hlindberg/testutils"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/memory"
+)
+
+var history = []string{
+	"Where is Ladakh?", "In India.",
+	"What borders it to the east?", "Tibet.",
+	"When did tourism start?", "In 1974.",
+}
+
+func Test_Condense(t *testing.T) {
+	fake := llm.NewFake()
+	fake.AddChatResponses(" Ladakh is in India, bordered by Tibet. ")
+	m := memory.New(fake, memory.WithThreshold(4), memory.WithKeep(3))
+
+	summary, recent, summarized, err := m.Condense(context.Background(), "The user asks about Ladakh.", history)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Ladakh is in India, bordered by Tibet.", summary, t)
+	testutils.CheckEqual(4, summarized, t)
+	testutils.CheckEqual([]string{"When did tourism start?", "In 1974."}, recent, t)
+
+	// The previous summary and the summarized messages are in the prompt, not the recent ones.
+	prompt := fake.Calls()[0].UserMessage
+	testutils.CheckTrue(strings.Contains(prompt, "The user asks about Ladakh."), t)
+	testutils.CheckTrue(strings.Contains(prompt, "Assistant: Tibet."), t)
+	testutils.CheckFalse(strings.Contains(prompt, "1974"), t)
+}
+
+func Test_CondenseWithinThreshold(t *testing.T) {
+	fake := llm.NewFake()
+	m := memory.New(fake)
+
+	summary, recent, summarized, err := m.Condense(context.Background(), "Earlier summary.", history)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Earlier summary.", summary, t)
+	testutils.CheckEqual(history, recent, t)
+	testutils.CheckEqual(0, summarized, t)
+	testutils.CheckEqual(0, len(fake.Calls()), t)
+}
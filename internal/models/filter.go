@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FilterOperator is the operator of a Filter expression.
+type FilterOperator string
+
+// Supported filter operators.
+const (
+	FilterEq    FilterOperator = "eq"
+	FilterIn    FilterOperator = "in"
+	FilterRange FilterOperator = "range"
+	FilterAnd   FilterOperator = "and"
+	FilterOr    FilterOperator = "or"
+	FilterNot   FilterOperator = "not"
+)
+
+// Filter is a backend neutral expression restricting the objects a similarity search can return.
+// Properties are the JSON names of the ContainerNodeVector fields, e.g. reference_url or section_number.
+//
+//   - eq: Property equals Value. On array properties such as keywords, any element may match.
+//   - in: Property equals one of Values.
+//   - range: Property is between Min and Max, both inclusive and optional. Numbers or RFC 3339 dates.
+//   - and, or: All or any of Operands match.
+//   - not: The single operand doesn't match. On array properties, no element may match.
+//     Weaviate can't express that and rejects not over array properties.
+//
+// Example: {"operator": "and", "operands": [{"operator": "eq", "property": "reference_url", "value": "https://..."},
+// {"operator": "range", "property": "section_number", "min": 2, "max": 4}]}
+type Filter struct {
+	Operator FilterOperator `json:"operator"`
+	Property string         `json:"property,omitempty"`
+	Value    interface{}    `json:"value,omitempty"`
+	Values   []interface{}  `json:"values,omitempty"`
+	Min      interface{}    `json:"min,omitempty"`
+	Max      interface{}    `json:"max,omitempty"`
+	Operands []Filter       `json:"operands,omitempty"`
+}
+
+// Eq returns a filter matching objects whose property equals value.
+func Eq(property string, value interface{}) Filter {
+	return Filter{Operator: FilterEq, Property: property, Value: value}
+}
+
+// In returns a filter matching objects whose property equals one of values.
+func In(property string, values ...interface{}) Filter {
+	return Filter{Operator: FilterIn, Property: property, Values: values}
+}
+
+// Range returns a filter matching objects whose property is between min and max. A nil bound is open.
+func Range(property string, min, max interface{}) Filter {
+	return Filter{Operator: FilterRange, Property: property, Min: min, Max: max}
+}
+
+// And returns a filter matching objects matching all operands.
+func And(operands ...Filter) Filter {
+	return Filter{Operator: FilterAnd, Operands: operands}
+}
+
+// Or returns a filter matching objects matching any of the operands.
+func Or(operands ...Filter) Filter {
+	return Filter{Operator: FilterOr, Operands: operands}
+}
+
+// Not returns a filter matching objects not matching operand.
+func Not(operand Filter) Filter {
+	return Filter{Operator: FilterNot, Operands: []Filter{operand}}
+}
+
+// Validate checks that the filter and its operands are well formed.
+func (f Filter) Validate() error {
+	switch f.Operator {
+	case FilterEq:
+		if f.Property == "" || f.Value == nil {
+			return errors.New("eq filter requires a property and a value")
+		}
+	case FilterIn:
+		if f.Property == "" || len(f.Values) == 0 {
+			return errors.New("in filter requires a property and values")
+		}
+	case FilterRange:
+		if f.Property == "" || (f.Min == nil && f.Max == nil) {
+			return errors.New("range filter requires a property and a min or max")
+		}
+	case FilterAnd, FilterOr:
+		if len(f.Operands) == 0 {
+			return fmt.Errorf("%s filter requires operands", f.Operator)
+		}
+	case FilterNot:
+		if len(f.Operands) != 1 {
+			return errors.New("not filter requires exactly one operand")
+		}
+	default:
+		return fmt.Errorf("unknown filter operator %q", f.Operator)
+	}
+
+	for _, operand := range f.Operands {
+		if err := operand.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type FunctionCall struct {
 	Name string `json:"name,omitempty"`
 	// call function with arguments in JSON format
@@ -52,26 +54,117 @@ type Definition struct {
 }
 
 // ConversationRequest handles a conversation request.
+// The history of the conversation is kept on the server under its ConversationID, the request only holds the new Query.
 type ConversationRequest struct {
-	ConversationID string   `json:"ConversationID"`
-	ClassID        string   `json:"ClassID"`
-	ChatHistory    []string `json:"ChatHistory"`
-	Query          string   `json:"Query"`
+	// ConversationID continues a conversation, a new one is started when it is empty or unknown.
+	ConversationID string `json:"ConversationID"`
+	ClassID        string `json:"ClassID"`
+	Query          string `json:"Query"`
+	// SuggestionCount optionally sets the number of follow up questions suggested, 0 for none. Defaults to 3.
+	SuggestionCount *int `json:"SuggestionCount,omitempty"`
+	// TTL optionally sets how many seconds the conversation is kept after its last turn, 0 to keep it forever.
+	TTL *int64 `json:"TTL,omitempty"`
+	// Filter optionally scopes retrieval to matching sources, see Filter.
+	Filter *Filter `json:"Filter,omitempty"`
+	// Retrieval optionally selects how the relevant content is searched.
+	Retrieval *Retrieval `json:"Retrieval,omitempty"`
+}
+
+// Retrieval modes.
+const (
+	RetrievalVector = "vector"
+	RetrievalHybrid = "hybrid"
+)
+
+// Retrieval selects how the relevant content of a conversation is searched.
+type Retrieval struct {
+	// Mode is "vector", the default, or "hybrid" to also match the words of the query with BM25.
+	Mode string `json:"mode,omitempty"`
+	// Alpha weights the vector ranking of hybrid searches, from 0 for keywords only to 1 for vectors only. Defaults to 0.5.
+	Alpha *float32 `json:"alpha,omitempty"`
+	// Fusion combines the hybrid rankings, "alpha" for weighted scores, the default, or "rrf" for reciprocal rank fusion.
+	Fusion string `json:"fusion,omitempty"`
+	// MaxDistance drops the content further from the question, in the metric of the vector store. Defaults to 0.8.
+	MaxDistance *float32 `json:"max_distance,omitempty"`
+	// MMRLambda diversifies the content with maximal marginal relevance when set,
+	// from 0 for the most diverse sources to 1 for the most relevant ones.
+	MMRLambda *float32 `json:"mmr_lambda,omitempty"`
 }
 
 // ConversationResponse is sent back as response from the API.
 type ConversationResponse struct {
-	ConversationID string   `json:"ConversationID"`
-	ClassID        string   `json:"ClassID"`
-	Query          string   `json:"Query"`
+	ConversationID string `json:"ConversationID"`
+	ClassID        string `json:"ClassID"`
+	Query          string `json:"Query"`
+	// RewrittenQuery is the standalone search query the follow up Query was rewritten into, for debugging retrieval.
+	RewrittenQuery string   `json:"RewrittenQuery,omitempty"`
 	Answer         Answer   `json:"Answer"`
 	Suggestions    []string `json:"Suggestions"`
+	// Summary is the running summary of the older turns of the conversation.
+	Summary string `json:"Summary,omitempty"`
+	// Context reports how the prompt was fit into the token budget of the model, and what was left out.
+	Context *ContextReport `json:"Context,omitempty"`
+}
+
+// ContextReport describes the prompt packed into the token budget of the chat model.
+type ContextReport struct {
+	// Budget is the number of tokens the prompt may use, the context window of the model minus the room left for the answer.
+	Budget int `json:"budget"`
+	// Tokens is the number of tokens of the prompt sent.
+	Tokens int `json:"tokens"`
+	// DroppedHistory is the number of history messages left out, always the oldest ones after the summarized ones.
+	DroppedHistory int `json:"dropped_history,omitempty"`
+	// DroppedSources are the titles of the retrieved contents left out, always the least relevant ones.
+	DroppedSources []string `json:"dropped_sources,omitempty"`
 }
 
+// Types of Answer.
+const (
+	// AnswerGrounded answers the question from the retrieved contents.
+	AnswerGrounded = "answer"
+	// AnswerInsufficientContext says the retrieved contents don't support an answer, and lists the closest sources found.
+	AnswerInsufficientContext = "insufficient_context"
+)
+
 // Answer struct holds answer and relevant references
 type Answer struct {
-	Answer  string   `json:"Answer"`
+	// Type is AnswerGrounded, or AnswerInsufficientContext when the model wasn't asked to answer.
+	Type string `json:"Type"`
+	// Answer cites its facts with markers such as [1], the Marker of its Citations.
+	Answer string `json:"Answer"`
+	// Sources are the reference URLs, or titles, of the cited contents, or of all the contents given to the model when it cited none.
 	Sources []string `json:"Sources"`
+	// Citations are the retrieved contents cited by the Answer, in the order they are first cited.
+	Citations []Citation `json:"Citations,omitempty"`
+	// NoRelevantSources is set on an AnswerInsufficientContext answer when nothing was retrieved within the max distance,
+	// rather than contents too weak to answer. Type tells whether the question was answered.
+	NoRelevantSources bool `json:"NoRelevantSources,omitempty"`
+	// ClosestSources are the contents closest to the question of an AnswerInsufficientContext answer.
+	ClosestSources []Citation `json:"ClosestSources,omitempty"`
+}
+
+// Citation links a citation marker of an answer to the retrieved content it cites.
+type Citation struct {
+	// Marker is the number in the citation markers of the answer, e.g. 2 for [2].
+	Marker int `json:"marker"`
+	// ID is the object ID of the content in the vector store.
+	ID             string `json:"id"`
+	Title          string `json:"title,omitempty"`
+	ReferenceTitle string `json:"reference_title,omitempty"`
+	ReferenceURL   string `json:"reference_url,omitempty"`
+	SectionNumber  int    `json:"section_number,omitempty"`
+	// The provenance of the content, to jump to the original passage, see ContainerNodeVector.
+	// The fields are left out when the content has none, the numbers are pointers so 0 is told apart.
+	SourcePath   string     `json:"source_path,omitempty"`
+	ArticleIndex *int       `json:"article_index,omitempty"`
+	StartOffset  *int       `json:"start_offset,omitempty"`
+	EndOffset    *int       `json:"end_offset,omitempty"`
+	StartChar    *int       `json:"start_char,omitempty"`
+	EndChar      *int       `json:"end_char,omitempty"`
+	ContentHash  string     `json:"content_hash,omitempty"`
+	IngestedAt   *time.Time `json:"ingested_at,omitempty"`
+	// Quotes are the sentences of the answer citing the content.
+	Quotes []string `json:"quotes,omitempty"`
 }
 
 // Class struct to hold static Class info.
@@ -85,6 +178,16 @@ type ContainerNode struct {
 	Keywords   []string `json:"keywords"`
 	Questions  []string `json:"questions"`
 	NodeNumber int      `json:"sectionNumber"`
+	// Offsets locates the content in the split text, it is nil when the content isn't from the text.
+	Offsets *Offsets `json:"offsets,omitempty"`
+}
+
+// Offsets locates a passage in its source, StartOffset and EndOffset in bytes, StartChar and EndChar in characters.
+type Offsets struct {
+	StartOffset int `json:"start_offset"`
+	EndOffset   int `json:"end_offset"`
+	StartChar   int `json:"start_char"`
+	EndChar     int `json:"end_char"`
 }
 
 // Container Holds information about a particular context, this can be a document or any other text.
@@ -105,12 +208,28 @@ type ContainerNodeVector struct {
 	NodeNumber   int       `json:"section_number"`
 	RefTitle     string    `json:"reference_title"`
 	ReferenceURL string    `json:"reference_url"`
-	Embedding    []float32 `json:"embedding"`
+	IngestedAt   time.Time `json:"ingested_at"`
+	// SourcePath is the file the node was read from, and ArticleIndex the index of its article in the file.
+	SourcePath   string `json:"source_path"`
+	ArticleIndex int    `json:"article_index"`
+	// The offsets locate the content in the file, or in the added text without a file. They are nil, and not
+	// stored, when the content isn't from the source.
+	StartOffset *int `json:"start_offset"`
+	EndOffset   *int `json:"end_offset"`
+	StartChar   *int `json:"start_char"`
+	EndChar     *int `json:"end_char"`
+	// ContentHash is the hex SHA-256 of the content, to tell whether the source changed since.
+	ContentHash string    `json:"content_hash"`
+	Embedding   []float32 `json:"embedding"`
 }
 
 type IntellichunkRequest struct {
 	ClassName string
 	LongText  string
+	// Strategy is the chunking strategy, "llm" (default), "token", "recursive", "markdown" or "semantic".
+	Strategy string
+	// Metadata has the function model write the keywords and questions of the nodes split by the other strategies.
+	Metadata bool
 }
 
 // More generic data holders
@@ -0,0 +1,136 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// Environment variables configuring the HTTPReranker.
+const (
+	RerankURLEnv    = "RERANK_URL"
+	RerankModelEnv  = "RERANK_MODEL"
+	RerankAPIKeyEnv = "RERANK_API_KEY"
+
+	// DefaultRerankURL is the rerank endpoint of a local llama.cpp server started with --reranking.
+	DefaultRerankURL = "http://localhost:8080/v1/rerank"
+)
+
+// HTTPReranker scores the candidates with a cross-encoder served behind a Cohere compatible rerank endpoint,
+// as exposed by llama.cpp, vLLM, Jina or Cohere.
+type HTTPReranker struct {
+	URL        string
+	Model      string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+var _ Reranker = (*HTTPReranker)(nil)
+
+// HTTPOption is a function that can modify the HTTPReranker configuration.
+type HTTPOption func(*HTTPReranker)
+
+// WithURL sets the rerank endpoint.
+func WithURL(url string) HTTPOption {
+	return func(r *HTTPReranker) {
+		r.URL = url
+	}
+}
+
+// WithModel sets the reranker model name sent with each request.
+func WithModel(model string) HTTPOption {
+	return func(r *HTTPReranker) {
+		r.Model = model
+	}
+}
+
+// NewHTTPReranker creates an HTTPReranker configured from RERANK_URL, RERANK_MODEL and RERANK_API_KEY and the options.
+func NewHTTPReranker(options ...HTTPOption) *HTTPReranker {
+	r := &HTTPReranker{
+		URL:        os.Getenv(RerankURLEnv),
+		Model:      os.Getenv(RerankModelEnv),
+		APIKey:     os.Getenv(RerankAPIKeyEnv),
+		HTTPClient: http.DefaultClient,
+	}
+	if r.URL == "" {
+		r.URL = DefaultRerankURL
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+type rerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type rerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// Rerank sends the contents of the candidates to the rerank endpoint. Candidates it doesn't score score 0.
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, candidates []vectorstore.SearchResult) ([]vectorstore.SearchResult, error) {
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	documents := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		documents = append(documents, content(candidate))
+	}
+	body, err := json.Marshal(rerankRequest{Model: r.Model, Query: query, Documents: documents, TopN: len(documents)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rerank request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rerank request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to rerank: status %s", resp.Status)
+	}
+
+	var rerankResp rerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rerankResp); err != nil {
+		return nil, fmt.Errorf("failed to decode rerank response: %w", err)
+	}
+
+	reranked := make([]vectorstore.SearchResult, len(candidates))
+	copy(reranked, candidates)
+	for i := range reranked {
+		reranked[i].Score = 0
+	}
+	for _, result := range rerankResp.Results {
+		if result.Index >= 0 && result.Index < len(reranked) {
+			reranked[result.Index].Score = result.RelevanceScore
+		}
+	}
+	sortByScore(reranked)
+	return reranked, nil
+}
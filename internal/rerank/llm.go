@@ -0,0 +1,109 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/cckalen/intellichunk/internal/templateprompt"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// LLMReranker asks a language model to rate the relevance of every candidate to the query, from 0 to 10.
+// All candidates are rated in a single function call.
+type LLMReranker struct {
+	model llm.FunctionModel
+}
+
+var _ Reranker = (*LLMReranker)(nil)
+
+// NewLLMReranker creates an LLMReranker judging with model.
+func NewLLMReranker(model llm.FunctionModel) *LLMReranker {
+	return &LLMReranker{model: model}
+}
+
+var _scorePassages = []models.FunctionDefinition{
+	{
+		Name:        "score_passages",
+		Description: "Rates the relevance of each passage to the question",
+		Parameters: models.Definition{
+			Type: models.Object,
+			Properties: map[string]models.Definition{
+				"scores": {
+					Type: models.Array,
+					Items: &models.Definition{
+						Type: models.Object,
+						Properties: map[string]models.Definition{
+							"index": {
+								Type:        models.Integer,
+								Description: "Index of the passage",
+							},
+							"score": {
+								Type:        models.Number,
+								Description: "Relevance of the passage to the question, from 0 to 10",
+							},
+						},
+						Required: []string{"index", "score"},
+					},
+					Description: "The score of every passage",
+				},
+			},
+			Required: []string{"scores"},
+		},
+	},
+}
+
+// Rerank rates the candidates with the model. Candidates the model doesn't rate score 0.
+func (r *LLMReranker) Rerank(ctx context.Context, query string, candidates []vectorstore.SearchResult) ([]vectorstore.SearchResult, error) {
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	var passages strings.Builder
+	for i, candidate := range candidates {
+		fmt.Fprintf(&passages, "[%d] %s\n\n", i, content(candidate))
+	}
+
+	tr, err := templateprompt.NewTemplateRenderer(templateprompt.RerankPrompt)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := tr.Render(map[string]string{
+		"Question": query,
+		"Passages": passages.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.model.ChatCompletionFunctionsOptions(ctx, prompt, _scorePassages, llm.WithTemperature(0.1))
+	if err != nil {
+		return nil, err
+	}
+
+	var rated struct {
+		Scores []struct {
+			Index int     `json:"index"`
+			Score float32 `json:"score"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(resp), &rated); err != nil {
+		return nil, fmt.Errorf("invalid passage scores: %w", err)
+	}
+
+	reranked := make([]vectorstore.SearchResult, len(candidates))
+	copy(reranked, candidates)
+	for i := range reranked {
+		reranked[i].Score = 0
+	}
+	for _, score := range rated.Scores {
+		if score.Index >= 0 && score.Index < len(reranked) {
+			reranked[score.Index].Score = score.Score
+		}
+	}
+	sortByScore(reranked)
+	return reranked, nil
+}
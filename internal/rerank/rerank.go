@@ -0,0 +1,100 @@
+// Package rerank rescores the candidates of a similarity search with a model better at judging relevance
+// than the embeddings, such as an LLM judge or a cross-encoder reranker.
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+// RerankerEnv selects the reranker used by New, reranking is disabled when it is empty or "none".
+const RerankerEnv = "RERANKER"
+
+// Reranker rescores the candidates retrieved for a query.
+type Reranker interface {
+	// Rerank returns the candidates sorted by relevance to the query, most relevant first, with Score set to the relevance.
+	Rerank(ctx context.Context, query string, candidates []vectorstore.SearchResult) ([]vectorstore.SearchResult, error)
+}
+
+// Provider creates a Reranker configured from the environment.
+type Provider func() (Reranker, error)
+
+var (
+	rerankersMu sync.RWMutex
+	rerankers   = make(map[string]Provider)
+)
+
+func init() {
+	Register("llm", func() (Reranker, error) {
+		model, err := llm.NewFunctionModel()
+		if err != nil {
+			return nil, err
+		}
+		return NewLLMReranker(model), nil
+	})
+	Register("local", func() (Reranker, error) {
+		return NewHTTPReranker(), nil
+	})
+}
+
+// Register makes a reranker available by name. Registering the same name twice replaces the previous reranker.
+func Register(name string, provider Provider) {
+	rerankersMu.Lock()
+	defer rerankersMu.Unlock()
+
+	if provider == nil {
+		panic("rerank: Register provider is nil")
+	}
+	rerankers[name] = provider
+}
+
+// Rerankers returns the sorted names of the registered rerankers.
+func Rerankers() []string {
+	rerankersMu.RLock()
+	defer rerankersMu.RUnlock()
+
+	names := make([]string, 0, len(rerankers))
+	for name := range rerankers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates the reranker registered under name.
+func Open(name string) (Reranker, error) {
+	rerankersMu.RLock()
+	provider, ok := rerankers[name]
+	rerankersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown reranker %q (registered: %v)", name, Rerankers())
+	}
+	return provider()
+}
+
+// New creates the reranker configured by RERANKER. It returns a nil Reranker when reranking is disabled.
+func New() (Reranker, error) {
+	name := os.Getenv(RerankerEnv)
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	return Open(name)
+}
+
+// sortByScore sorts the results by decreasing score, keeping the retrieval order for ties.
+func sortByScore(results []vectorstore.SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+}
+
+// content returns the text of a candidate that is judged.
+func content(result vectorstore.SearchResult) string {
+	text, _ := result.Properties["content"].(string)
+	return text
+}
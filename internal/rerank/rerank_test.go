@@ -0,0 +1,90 @@
+// Package rerank_test is the test suite for the rerank package.
+package rerank_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hlindberg/testutils"
+
+	"github.com/cckalen/intellichunk/internal/llm"
+	"github.com/cckalen/intellichunk/internal/rerank"
+	"github.com/cckalen/intellichunk/internal/vectorstore"
+)
+
+func candidates() []vectorstore.SearchResult {
+	return []vectorstore.SearchResult{
+		{ID: "a", Score: 0.9, Properties: map[string]interface{}{"content": "Ladakh is a region administered by India."}},
+		{ID: "b", Score: 0.8, Properties: map[string]interface{}{"content": "Tourism in Ladakh started in 1974."}},
+		{ID: "c", Score: 0.7, Properties: map[string]interface{}{"content": "Volcanoes erupt lava."}},
+	}
+}
+
+func ids(results []vectorstore.SearchResult) string {
+	var b strings.Builder
+	for _, result := range results {
+		b.WriteString(result.ID)
+	}
+	return b.String()
+}
+
+func Test_LLMReranker(t *testing.T) {
+	fake := llm.NewFake()
+	fake.AddFunctionResponses(`{"scores": [{"index": 0, "score": 3}, {"index": 1, "score": 9}]}`)
+
+	reranked, err := rerank.NewLLMReranker(fake).Rerank(context.Background(), "When did tourism start?", candidates())
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("bac", ids(reranked), t)
+	testutils.CheckEqual(float32(9), reranked[0].Score, t)
+	testutils.CheckEqual(float32(0), reranked[2].Score, t)
+
+	// Every passage is in the prompt with its index.
+	calls := fake.Calls()
+	testutils.CheckEqual("score_passages", calls[0].Function, t)
+	testutils.CheckTrue(strings.Contains(calls[0].SystemMessage, "[1] Tourism in Ladakh started in 1974."), t)
+
+	fake.AddFunctionResponses("not json")
+	_, err = rerank.NewLLMReranker(fake).Rerank(context.Background(), "When did tourism start?", candidates())
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_HTTPReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model     string   `json:"model"`
+			Query     string   `json:"query"`
+			Documents []string `json:"documents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "bge-reranker" || len(req.Documents) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"results": [{"index": 2, "relevance_score": 0.95}, {"index": 0, "relevance_score": 0.1}, {"index": 1, "relevance_score": 0.4}]}`))
+	}))
+	defer server.Close()
+
+	reranker := rerank.NewHTTPReranker(rerank.WithURL(server.URL), rerank.WithModel("bge-reranker"))
+	reranked, err := reranker.Rerank(context.Background(), "What is lava?", candidates())
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("cba", ids(reranked), t)
+	testutils.CheckEqual(float32(0.95), reranked[0].Score, t)
+
+	reranker = rerank.NewHTTPReranker(rerank.WithURL(server.URL), rerank.WithModel("other"))
+	_, err = reranker.Rerank(context.Background(), "What is lava?", candidates())
+	testutils.CheckNotNil(err, t)
+}
+
+func Test_NewReranker(t *testing.T) {
+	t.Setenv(rerank.RerankerEnv, "")
+	reranker, err := rerank.New()
+	testutils.CheckNotError(err, t)
+	testutils.CheckTrue(reranker == nil, t)
+
+	t.Setenv(rerank.RerankerEnv, "no-such-reranker")
+	_, err = rerank.New()
+	testutils.CheckNotNil(err, t)
+}
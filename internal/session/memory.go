@@ -0,0 +1,97 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the sessions in the process, they are lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string][]byte)}
+}
+
+// Get returns a copy of the session, so callers can't change the stored one.
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.load(id)
+}
+
+// Put stores a copy of the session.
+func (m *MemoryStore) Put(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = data
+	return nil
+}
+
+// List returns the sessions that didn't expire, dropping the expired ones.
+func (m *MemoryStore) List(ctx context.Context) ([]Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := make([]Info, 0, len(m.sessions))
+	for id := range m.sessions {
+		s, err := m.load(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, s.Info())
+	}
+	sortInfos(infos)
+	return infos, nil
+}
+
+// Delete removes the session.
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.load(id); err != nil {
+		return err
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+// load decodes the session with the id, dropping it when it expired. The lock must be held.
+func (m *MemoryStore) load(id string) (*Session, error) {
+	data, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Expired(time.Now()) {
+		delete(m.sessions, id)
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+// sortInfos sorts the sessions by most recent update first.
+func sortInfos(infos []Info) {
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].UpdatedAt.After(infos[j].UpdatedAt) })
+}
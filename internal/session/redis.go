@@ -0,0 +1,103 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// _redisKeyPrefix namespaces the session keys.
+const _redisKeyPrefix = "intellichunk:session:"
+
+// RedisStore keeps the sessions in a Redis compatible server (Redis, Valkey, KeyDB, Dragonfly),
+// each as a JSON string expiring with the session.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// NewRedisStore connects to the server at url, e.g. redis://:password@localhost:6379/0.
+func NewRedisStore(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	return &RedisStore{Client: redis.NewClient(options)}, nil
+}
+
+// Get returns the session with the id.
+func (store *RedisStore) Get(ctx context.Context, id string) (*Session, error) {
+	data, err := store.Client.Get(ctx, _redisKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session %s: %w", id, err)
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// Put creates or replaces the session, the server deletes it when it expires.
+func (store *RedisStore) Put(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if s.ExpiresAt != nil {
+		ttl = time.Until(*s.ExpiresAt)
+		if ttl <= 0 {
+			return store.Client.Del(ctx, _redisKeyPrefix+s.ID).Err()
+		}
+	}
+	if err := store.Client.Set(ctx, _redisKeyPrefix+s.ID, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to put session %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// List scans the session keys, the sessions are returned most recently updated first.
+func (store *RedisStore) List(ctx context.Context) ([]Info, error) {
+	infos := make([]Info, 0)
+	iter := store.Client.Scan(ctx, 0, _redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		s, err := store.Get(ctx, strings.TrimPrefix(iter.Val(), _redisKeyPrefix))
+		if err == ErrNotFound {
+			// Expired since the scan.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, s.Info())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	sortInfos(infos)
+	return infos, nil
+}
+
+// Delete removes the session with the id.
+func (store *RedisStore) Delete(ctx context.Context, id string) error {
+	n, err := store.Client.Del(ctx, _redisKeyPrefix+id).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete session %s: %w", id, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Environment variables used to select and configure the session store.
+const (
+	StoreEnv      = "SESSION_STORE"
+	SQLitePathEnv = "SESSION_SQLITE_PATH"
+	RedisURLEnv   = "SESSION_REDIS_URL"
+	TTLEnv        = "SESSION_TTL"
+
+	// DefaultStore is used when no store is configured.
+	DefaultStore = "memory"
+	// DefaultSQLitePath is the database of the sqlite store when SESSION_SQLITE_PATH isn't set.
+	DefaultSQLitePath = "sessions.db"
+	// DefaultRedisURL is the server of the redis store when SESSION_REDIS_URL isn't set.
+	DefaultRedisURL = "redis://localhost:6379/0"
+	// DefaultTTL is how long sessions are kept after their last turn when SESSION_TTL isn't set.
+	DefaultTTL = 24 * time.Hour
+)
+
+// Provider opens a Store configured from the environment.
+type Provider func() (Store, error)
+
+var (
+	storesMu sync.RWMutex
+	stores   = make(map[string]Provider)
+)
+
+func init() {
+	Register("memory", openSharedMemoryStore)
+	Register("sqlite", openSharedSQLiteStore)
+	Register("redis", openSharedRedisStore)
+}
+
+// Register makes a store available by name. Registering the same name twice replaces the previous store.
+func Register(name string, provider Provider) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	if provider == nil {
+		panic("session: Register provider is nil")
+	}
+	stores[name] = provider
+}
+
+// Stores returns the sorted names of the registered stores.
+func Stores() []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the store registered under name.
+func Open(name string) (Store, error) {
+	storesMu.RLock()
+	provider, ok := stores[name]
+	storesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown session store %q (registered: %v)", name, Stores())
+	}
+	return provider()
+}
+
+// NewStore opens the store configured by SESSION_STORE, in memory by default.
+func NewStore() (Store, error) {
+	return Open(envOrDefault(StoreEnv, DefaultStore))
+}
+
+// TTLFromEnv returns the session TTL configured by SESSION_TTL, a duration such as "2h", or "0" to keep sessions forever.
+func TTLFromEnv() (time.Duration, error) {
+	value := os.Getenv(TTLEnv)
+	if value == "" {
+		return DefaultTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a duration such as 2h", TTLEnv, value)
+	}
+	return ttl, nil
+}
+
+var (
+	sharedMemoryOnce  sync.Once
+	sharedMemoryStore *MemoryStore
+)
+
+// openSharedMemoryStore returns the process wide MemoryStore, so sessions outlive the requests.
+func openSharedMemoryStore() (Store, error) {
+	sharedMemoryOnce.Do(func() {
+		sharedMemoryStore = NewMemoryStore()
+	})
+	return sharedMemoryStore, nil
+}
+
+var (
+	sharedSQLiteOnce  sync.Once
+	sharedSQLiteStore *SQLiteStore
+	sharedSQLiteErr   error
+)
+
+// openSharedSQLiteStore returns the process wide SQLiteStore at SESSION_SQLITE_PATH.
+func openSharedSQLiteStore() (Store, error) {
+	sharedSQLiteOnce.Do(func() {
+		sharedSQLiteStore, sharedSQLiteErr = NewSQLiteStore(envOrDefault(SQLitePathEnv, DefaultSQLitePath))
+	})
+	if sharedSQLiteErr != nil {
+		return nil, sharedSQLiteErr
+	}
+	return sharedSQLiteStore, nil
+}
+
+var (
+	sharedRedisOnce  sync.Once
+	sharedRedisStore *RedisStore
+	sharedRedisErr   error
+)
+
+// openSharedRedisStore returns the process wide RedisStore at SESSION_REDIS_URL, so its client and connection pool
+// are opened once instead of on every request.
+func openSharedRedisStore() (Store, error) {
+	sharedRedisOnce.Do(func() {
+		sharedRedisStore, sharedRedisErr = NewRedisStore(envOrDefault(RedisURLEnv, DefaultRedisURL))
+	})
+	if sharedRedisErr != nil {
+		return nil, sharedRedisErr
+	}
+	return sharedRedisStore, nil
+}
+
+// envOrDefault returns the value of the environment variable key, or fallback when it is empty.
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,133 @@
+// Package session persists conversations on the server, keyed by their ConversationID,
+// so clients only send the new question of every turn.
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Message roles.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ErrNotFound is returned for sessions that don't exist or expired.
+var ErrNotFound = errors.New("session not found")
+
+// Message is a turn of the transcript of a session.
+type Message struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a conversation with a class.
+type Session struct {
+	ID      string `json:"id"`
+	ClassID string `json:"class_id"`
+	// Messages is the whole transcript, questions and answers alternating.
+	Messages []Message `json:"messages"`
+	// Summary is the running summary of the oldest Summarized messages, which aren't sent to the model anymore.
+	Summary    string `json:"summary,omitempty"`
+	Summarized int    `json:"summarized,omitempty"`
+	// TTLSeconds is how long the session is kept after its last turn, forever when 0.
+	TTLSeconds int64     `json:"ttl_seconds,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// ExpiresAt is when the session is deleted, nil when it is kept forever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Info describes a session without its transcript.
+type Info struct {
+	ID        string     `json:"id"`
+	ClassID   string     `json:"class_id"`
+	Messages  int        `json:"messages"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// New creates an empty session kept for ttl after its last turn, forever when ttl is 0.
+func New(id, classID string, ttl time.Duration) *Session {
+	now := time.Now().UTC()
+	s := &Session{ID: id, ClassID: classID, CreatedAt: now}
+	s.SetTTL(ttl)
+	s.touch(now)
+	return s
+}
+
+// SetTTL sets how long the session is kept after its last turn, forever when ttl is 0.
+func (s *Session) SetTTL(ttl time.Duration) {
+	s.TTLSeconds = int64(ttl / time.Second)
+	s.touch(s.UpdatedAt)
+}
+
+// TTL returns how long the session is kept after its last turn.
+func (s *Session) TTL() time.Duration {
+	return time.Duration(s.TTLSeconds) * time.Second
+}
+
+// Append adds a question and its answer to the transcript and extends the expiry of the session.
+func (s *Session) Append(question, answer string) {
+	now := time.Now().UTC()
+	s.Messages = append(s.Messages,
+		Message{Role: RoleUser, Content: question, CreatedAt: now},
+		Message{Role: RoleAssistant, Content: answer, CreatedAt: now},
+	)
+	s.touch(now)
+}
+
+// History returns the messages that aren't summarized, questions at even and answers at odd indexes as llm.ChatModel expects.
+func (s *Session) History() []string {
+	if s.Summarized >= len(s.Messages) {
+		return nil
+	}
+	history := make([]string, 0, len(s.Messages)-s.Summarized)
+	for _, message := range s.Messages[s.Summarized:] {
+		history = append(history, message.Content)
+	}
+	return history
+}
+
+// Expired tells if the session expired at now.
+func (s *Session) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// Info returns the description of the session.
+func (s *Session) Info() Info {
+	return Info{
+		ID:        s.ID,
+		ClassID:   s.ClassID,
+		Messages:  len(s.Messages),
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// touch sets the time of the last turn and the expiry following it.
+func (s *Session) touch(now time.Time) {
+	s.UpdatedAt = now
+	s.ExpiresAt = nil
+	if s.TTLSeconds > 0 {
+		expiresAt := now.Add(s.TTL())
+		s.ExpiresAt = &expiresAt
+	}
+}
+
+// Store persists sessions. Expired sessions are never returned.
+type Store interface {
+	// Get returns the session with the id, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Session, error)
+	// Put creates or replaces the session.
+	Put(ctx context.Context, s *Session) error
+	// List returns the sessions, most recently updated first.
+	List(ctx context.Context) ([]Info, error)
+	// Delete removes the session with the id, or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+}
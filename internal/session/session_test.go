@@ -0,0 +1,118 @@
+// Package session_test is the test suite for the session package.
+package session_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hlindberg/testutils"
+
+	"github.com/cckalen/intellichunk/internal/session"
+)
+
+// checkStore runs the behavior every store shares.
+func checkStore(t *testing.T, store session.Store) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "missing")
+	testutils.CheckTrue(err == session.ErrNotFound, t)
+
+	s := session.New("convo-1", "Class_demo", 0)
+	s.Append("Where is Ladakh?", "In India.")
+	s.Summary = "The user asks about Ladakh."
+	testutils.CheckNotError(store.Put(ctx, s), t)
+
+	got, err := store.Get(ctx, "convo-1")
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual("Class_demo", got.ClassID, t)
+	testutils.CheckEqual([]string{"Where is Ladakh?", "In India."}, got.History(), t)
+	testutils.CheckEqual(session.RoleAssistant, got.Messages[1].Role, t)
+	testutils.CheckEqual("The user asks about Ladakh.", got.Summary, t)
+	testutils.CheckTrue(got.ExpiresAt == nil, t)
+
+	other := session.New("convo-2", "Class_demo", time.Hour)
+	testutils.CheckNotError(store.Put(ctx, other), t)
+	infos, err := store.List(ctx)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(2, len(infos), t)
+	testutils.CheckEqual("convo-2", infos[0].ID, t)
+	testutils.CheckEqual(2, infos[1].Messages, t)
+
+	testutils.CheckNotError(store.Delete(ctx, "convo-1"), t)
+	_, err = store.Get(ctx, "convo-1")
+	testutils.CheckTrue(err == session.ErrNotFound, t)
+	testutils.CheckTrue(store.Delete(ctx, "convo-1") == session.ErrNotFound, t)
+}
+
+func Test_MemoryStore(t *testing.T) {
+	checkStore(t, session.NewMemoryStore())
+}
+
+func Test_SQLiteStore(t *testing.T) {
+	store, err := session.NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+	testutils.CheckNotError(err, t)
+	defer store.DB.Close()
+	checkStore(t, store)
+}
+
+func Test_RedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := session.NewRedisStore("redis://" + server.Addr())
+	testutils.CheckNotError(err, t)
+	checkStore(t, store)
+
+	// The server expires the sessions.
+	ctx := context.Background()
+	testutils.CheckNotError(store.Put(ctx, session.New("convo-3", "Class_demo", time.Minute)), t)
+	server.FastForward(2 * time.Minute)
+	_, err = store.Get(ctx, "convo-3")
+	testutils.CheckTrue(err == session.ErrNotFound, t)
+}
+
+func Test_SharedRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	t.Setenv(session.StoreEnv, "redis")
+	t.Setenv(session.RedisURLEnv, "redis://"+server.Addr())
+
+	// Every conversation and request opens the store, they share its client.
+	first, err := session.NewStore()
+	testutils.CheckNotError(err, t)
+	second, err := session.NewStore()
+	testutils.CheckNotError(err, t)
+	testutils.CheckTrue(first == second, t)
+	checkStore(t, first)
+}
+
+func Test_Expiry(t *testing.T) {
+	ctx := context.Background()
+	store := session.NewMemoryStore()
+	s := session.New("convo-1", "Class_demo", time.Hour)
+	expired := time.Now().Add(-time.Minute)
+	s.ExpiresAt = &expired
+	testutils.CheckNotError(store.Put(ctx, s), t)
+
+	_, err := store.Get(ctx, "convo-1")
+	testutils.CheckTrue(err == session.ErrNotFound, t)
+	infos, err := store.List(ctx)
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(0, len(infos), t)
+}
+
+func Test_TTLFromEnv(t *testing.T) {
+	t.Setenv(session.TTLEnv, "")
+	ttl, err := session.TTLFromEnv()
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(session.DefaultTTL, ttl, t)
+
+	t.Setenv(session.TTLEnv, "30m")
+	ttl, err = session.TTLFromEnv()
+	testutils.CheckNotError(err, t)
+	testutils.CheckEqual(30*time.Minute, ttl, t)
+
+	t.Setenv(session.TTLEnv, "soon")
+	_, err = session.TTLFromEnv()
+	testutils.CheckNotNil(err, t)
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// Register the pure Go sqlite driver, so the builds don't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps the sessions in a SQLite database, each as a JSON document.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// NewSQLiteStore opens the SQLite database at path, creating it and its sessions table when missing.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite: %w", err)
+	}
+	// SQLite allows a single writer, serializing the connections avoids "database is locked" errors.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		expires_at INTEGER
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the sessions table: %w", err)
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+// Get returns the session with the id.
+func (store *SQLiteStore) Get(ctx context.Context, id string) (*Session, error) {
+	var data string
+	err := store.DB.QueryRowContext(ctx,
+		`SELECT data FROM sessions WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		id, time.Now().UnixNano(),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session %s: %w", id, err)
+	}
+
+	var s Session
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// Put creates or replaces the session.
+func (store *SQLiteStore) Put(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var expiresAt interface{}
+	if s.ExpiresAt != nil {
+		expiresAt = s.ExpiresAt.UnixNano()
+	}
+
+	_, err = store.DB.ExecContext(ctx,
+		`INSERT INTO sessions (id, data, updated_at, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at, expires_at = excluded.expires_at`,
+		s.ID, string(data), s.UpdatedAt.UnixNano(), expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to put session %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// List returns the sessions that didn't expire, deleting the expired ones.
+func (store *SQLiteStore) List(ctx context.Context) ([]Info, error) {
+	now := time.Now().UnixNano()
+	if _, err := store.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return nil, fmt.Errorf("failed to delete the expired sessions: %w", err)
+	}
+
+	rows, err := store.DB.QueryContext(ctx, `SELECT data FROM sessions ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	infos := make([]Info, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var s Session
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return nil, fmt.Errorf("failed to decode session: %w", err)
+		}
+		infos = append(infos, s.Info())
+	}
+	return infos, rows.Err()
+}
+
+// Delete removes the session with the id.
+func (store *SQLiteStore) Delete(ctx context.Context, id string) error {
+	result, err := store.DB.ExecContext(ctx,
+		`DELETE FROM sessions WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		id, time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete session %s: %w", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -2,9 +2,48 @@ package templateprompt
 
 // Constants defining the various prompt templates.
 const (
-	HelperAgentPrompt = `Your are helper agent, provide helpful answers solely using the facts provided below:
+	HelperAgentPrompt = `Your are helper agent, provide helpful answers solely using the facts provided below.
+	Cite the facts you use with their number in square brackets right after the sentence using them, e.g. [1] or [1, 3]. Only cite the numbers below.
 
 	Topic Details: {{.Details}} 
 	
-	Helpful Facts: {{.SSContent}}`
+	Helpful Facts: {{.SSContent}}{{if .Summary}}
+
+	Summary of the conversation so far: {{.Summary}}{{end}}`
+
+	CondenseQuestionPrompt = `Given the conversation below and a follow up question, rephrase the follow up question into a standalone search query, in its original language, that can be understood without the conversation. Replace pronouns and references such as "it" or "#3" with what they refer to. Return only the query.
+
+	Summary of the earlier conversation: {{.Summary}}
+
+	Conversation:
+{{.Messages}}
+	Follow up question: {{.Question}}`
+
+	SuggestQuestionsPrompt = `Suggest {{.Count}} short follow up questions the user may ask next about the facts below, which the facts answer. Don't repeat the questions already asked. Write one question per line, without numbering.
+
+	Facts: {{.Facts}}
+
+	Questions already asked:
+{{.Asked}}
+	Last answer: {{.Answer}}`
+
+	SummarizeHistoryPrompt = `Progressively summarize the conversation between a user and a helper agent, adding the new messages to the previous summary. Keep the questions asked, the facts given in the answers and anything the user may refer to later. Return only the new summary.
+
+	Previous summary: {{.Summary}}
+
+	New messages:
+{{.Messages}}`
+
+	VerifyAnswerablePrompt = `Decide whether the facts below are enough to answer the question, without any other knowledge. Answer only YES or NO.
+
+	Facts: {{.Facts}}
+
+	Question: {{.Question}}`
+
+	RerankPrompt = `Rate how useful each passage is to answer the question, from 0 (unrelated) to 10 (answers it directly). Rate every passage by its index.
+
+	Question: {{.Question}}
+
+	Passages:
+{{.Passages}}`
 )
@@ -0,0 +1,76 @@
+package vectorstore
+
+import (
+	"fmt"
+	"math"
+)
+
+// DistanceMetric is the measure used to compare vectors, lower distances are more similar.
+type DistanceMetric string
+
+// Supported distance metrics, named after their Weaviate equivalents.
+const (
+	Cosine    DistanceMetric = "cosine"
+	Dot       DistanceMetric = "dot"
+	L2Squared DistanceMetric = "l2-squared"
+)
+
+// ParseDistanceMetric returns the DistanceMetric for name, an empty name is Cosine.
+func ParseDistanceMetric(name string) (DistanceMetric, error) {
+	switch DistanceMetric(name) {
+	case "", Cosine:
+		return Cosine, nil
+	case Dot:
+		return Dot, nil
+	case L2Squared, "l2":
+		return L2Squared, nil
+	default:
+		return "", fmt.Errorf("unknown distance metric %q", name)
+	}
+}
+
+// Distance returns the distance between a and b. Vectors of different lengths are infinitely far apart.
+func (m DistanceMetric) Distance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return float32(math.Inf(1))
+	}
+
+	switch m {
+	case Dot:
+		return -dot(a, b)
+	case L2Squared:
+		var sum float32
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return sum
+	default:
+		normA, normB := norm(a), norm(b)
+		if normA == 0 || normB == 0 {
+			return 1
+		}
+		return 1 - dot(a, b)/(normA*normB)
+	}
+}
+
+// Similarity converts a distance of the metric to a score where higher is more similar:
+// the cosine similarity for Cosine, the dot product for Dot and the negated distance for L2Squared.
+func (m DistanceMetric) Similarity(distance float32) float32 {
+	if m == Cosine || m == "" {
+		return 1 - distance
+	}
+	return -distance
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func norm(a []float32) float32 {
+	return float32(math.Sqrt(float64(dot(a, a))))
+}
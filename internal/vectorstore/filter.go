@@ -0,0 +1,176 @@
+package vectorstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cckalen/intellichunk/internal/models"
+)
+
+// WithFilter restricts the search to the objects matching filter.
+func WithFilter(filter *models.Filter) SearchOption {
+	return func(o *SearchOptions) {
+		o.Filter = filter
+	}
+}
+
+// arrayProperties are the properties holding several values, which eq, in and range filters match on any element.
+var arrayProperties = map[string]bool{"keywords": true, "questions": true}
+
+// validateSearchFilter validates the filter of the options if there is one.
+func validateSearchFilter(options *SearchOptions) error {
+	if options.Filter == nil {
+		return nil
+	}
+	if err := options.Filter.Validate(); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	return nil
+}
+
+// matchFilter evaluates a validated filter against the properties of an object, for stores filtering in Go.
+func matchFilter(f *models.Filter, properties map[string]interface{}) bool {
+	if f == nil {
+		return true
+	}
+
+	switch f.Operator {
+	case models.FilterEq:
+		return matchAny(properties[f.Property], func(v interface{}) bool { return filterValueEqual(v, f.Value) })
+	case models.FilterIn:
+		return matchAny(properties[f.Property], func(v interface{}) bool {
+			for _, value := range f.Values {
+				if filterValueEqual(v, value) {
+					return true
+				}
+			}
+			return false
+		})
+	case models.FilterRange:
+		return matchAny(properties[f.Property], func(v interface{}) bool {
+			if f.Min != nil {
+				if c, ok := compareFilterValues(v, f.Min); !ok || c < 0 {
+					return false
+				}
+			}
+			if f.Max != nil {
+				if c, ok := compareFilterValues(v, f.Max); !ok || c > 0 {
+					return false
+				}
+			}
+			return true
+		})
+	case models.FilterAnd:
+		for i := range f.Operands {
+			if !matchFilter(&f.Operands[i], properties) {
+				return false
+			}
+		}
+		return true
+	case models.FilterOr:
+		for i := range f.Operands {
+			if matchFilter(&f.Operands[i], properties) {
+				return true
+			}
+		}
+		return false
+	case models.FilterNot:
+		return !matchFilter(&f.Operands[0], properties)
+	default:
+		return false
+	}
+}
+
+// matchAny applies match to the value, or to each element of array values.
+func matchAny(value interface{}, match func(interface{}) bool) bool {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && match(value)
+}
+
+func filterValueEqual(a, b interface{}) bool {
+	c, ok := compareFilterValues(a, b)
+	return ok && c == 0
+}
+
+// compareFilterValues compares numbers, RFC 3339 dates, strings and booleans. ok is false for incomparable values.
+func compareFilterValues(a, b interface{}) (c int, ok bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	}
+
+	if x, ok := toTime(a); ok {
+		if y, ok := toTime(b); ok {
+			return compareOrdered(x.UnixNano(), y.UnixNano()), true
+		}
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok || x != y {
+			return 1, ok
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func compareOrdered[T int64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// toFloat converts JSON and Go numbers to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// toTime converts RFC 3339 strings and times to time.Time.
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,303 @@
+package vectorstore
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Default HNSW parameters, see "Efficient and robust approximate nearest neighbor search
+// using Hierarchical Navigable Small World graphs" (Malkov & Yashunin).
+const (
+	_defaultHNSWM              = 16
+	_defaultHNSWEfConstruction = 200
+	_defaultHNSWEfSearch       = 64
+)
+
+// hnswGraph is a Hierarchical Navigable Small World graph over the objects of a class.
+// Deleted nodes are kept as tombstones so the graph stays connected, and are skipped in results.
+type hnswGraph struct {
+	metric         DistanceMetric
+	m              int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rng            *rand.Rand
+
+	nodes      []*hnswNode
+	entryPoint int
+	maxLevel   int
+	deleted    int
+}
+
+type hnswNode struct {
+	id         string
+	properties map[string]interface{}
+	vector     []float32
+	// neighbors holds the node indexes connected to this node on every level up to its own.
+	neighbors [][]int
+	deleted   bool
+}
+
+// hnswCandidate is a node index with its distance to the query.
+type hnswCandidate struct {
+	index    int
+	distance float32
+}
+
+func newHNSWGraph(metric DistanceMetric, m, efConstruction, efSearch int) *hnswGraph {
+	return &hnswGraph{
+		metric:         metric,
+		m:              m,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+		levelMult:      1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec // level sampling isn't security sensitive
+		entryPoint:     -1,
+	}
+}
+
+// len returns the number of live nodes.
+func (g *hnswGraph) len() int {
+	return len(g.nodes) - g.deleted
+}
+
+func (g *hnswGraph) randomLevel() int {
+	return int(-math.Log(1-g.rng.Float64()) * g.levelMult)
+}
+
+func (g *hnswGraph) distance(vector []float32, index int) float32 {
+	return g.metric.Distance(vector, g.nodes[index].vector)
+}
+
+// insert adds a node and connects it to its nearest neighbors on every level.
+func (g *hnswGraph) insert(id string, properties map[string]interface{}, vector []float32) {
+	index := len(g.nodes)
+	level := g.randomLevel()
+	node := &hnswNode{
+		id:         id,
+		properties: properties,
+		vector:     vector,
+		neighbors:  make([][]int, level+1),
+	}
+	g.nodes = append(g.nodes, node)
+
+	if g.entryPoint < 0 {
+		g.entryPoint = index
+		g.maxLevel = level
+		return
+	}
+
+	// Greedily descend through the levels above the new node.
+	entry := hnswCandidate{index: g.entryPoint, distance: g.distance(vector, g.entryPoint)}
+	for l := g.maxLevel; l > level; l-- {
+		entry = g.greedyClosest(vector, entry, l)
+	}
+
+	entries := []hnswCandidate{entry}
+	for l := minInt(level, g.maxLevel); l >= 0; l-- {
+		found := g.searchLayer(vector, entries, g.efConstruction, l)
+		neighbors := found
+		if len(neighbors) > g.m {
+			neighbors = neighbors[:g.m]
+		}
+		for _, neighbor := range neighbors {
+			node.neighbors[l] = append(node.neighbors[l], neighbor.index)
+			g.connect(neighbor.index, index, l)
+		}
+		entries = found
+	}
+
+	if level > g.maxLevel {
+		g.maxLevel = level
+		g.entryPoint = index
+	}
+}
+
+// connect adds an edge from -> to on level and prunes from's neighbors to the closest ones when it has too many.
+func (g *hnswGraph) connect(from, to, level int) {
+	node := g.nodes[from]
+	node.neighbors[level] = append(node.neighbors[level], to)
+
+	maxNeighbors := g.m
+	if level == 0 {
+		maxNeighbors = 2 * g.m
+	}
+	if len(node.neighbors[level]) <= maxNeighbors {
+		return
+	}
+
+	candidates := make([]hnswCandidate, 0, len(node.neighbors[level]))
+	for _, neighbor := range node.neighbors[level] {
+		candidates = append(candidates, hnswCandidate{index: neighbor, distance: g.distance(node.vector, neighbor)})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	node.neighbors[level] = node.neighbors[level][:0]
+	for _, c := range candidates[:maxNeighbors] {
+		node.neighbors[level] = append(node.neighbors[level], c.index)
+	}
+}
+
+// greedyClosest walks level towards the node closest to vector.
+func (g *hnswGraph) greedyClosest(vector []float32, entry hnswCandidate, level int) hnswCandidate {
+	for changed := true; changed; {
+		changed = false
+		for _, neighbor := range g.nodes[entry.index].neighbors[level] {
+			if d := g.distance(vector, neighbor); d < entry.distance {
+				entry = hnswCandidate{index: neighbor, distance: d}
+				changed = true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer returns up to ef nodes of level closest to vector, sorted by distance.
+func (g *hnswGraph) searchLayer(vector []float32, entries []hnswCandidate, ef, level int) []hnswCandidate {
+	visited := make(map[int]struct{}, ef*4)
+	candidates := &candidateHeap{}
+	results := &candidateHeap{max: true}
+
+	for _, entry := range entries {
+		visited[entry.index] = struct{}{}
+		heap.Push(candidates, entry)
+		heap.Push(results, entry)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		closest := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && closest.distance > results.items[0].distance {
+			break
+		}
+
+		for _, neighbor := range g.nodes[closest.index].neighbors[level] {
+			if _, ok := visited[neighbor]; ok {
+				continue
+			}
+			visited[neighbor] = struct{}{}
+
+			d := g.distance(vector, neighbor)
+			if results.Len() < ef || d < results.items[0].distance {
+				heap.Push(candidates, hnswCandidate{index: neighbor, distance: d})
+				heap.Push(results, hnswCandidate{index: neighbor, distance: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := append([]hnswCandidate(nil), results.items...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].distance < sorted[j].distance })
+	return sorted
+}
+
+// search returns the k live nodes closest to vector. When match is set, only the nodes it accepts are returned.
+func (g *hnswGraph) search(vector []float32, k int, match func(*hnswNode) bool) []hnswCandidate {
+	if g.entryPoint < 0 || k <= 0 {
+		return nil
+	}
+
+	entry := hnswCandidate{index: g.entryPoint, distance: g.distance(vector, g.entryPoint)}
+	for l := g.maxLevel; l > 0; l-- {
+		entry = g.greedyClosest(vector, entry, l)
+	}
+
+	// Tombstones are traversed but not returned, so look a bit further when there are some.
+	// Filtered out nodes are handled the same way, doubling ef until enough of them match or the whole graph was visited.
+	ef := maxInt(g.efSearch, k+g.deleted)
+	for {
+		found := g.searchLayer(vector, []hnswCandidate{entry}, ef, 0)
+
+		results := make([]hnswCandidate, 0, k)
+		for _, c := range found {
+			node := g.nodes[c.index]
+			if node.deleted || (match != nil && !match(node)) {
+				continue
+			}
+			results = append(results, c)
+			if len(results) == k {
+				break
+			}
+		}
+		if len(results) == k || match == nil || ef >= len(g.nodes) {
+			return results
+		}
+		ef *= 2
+	}
+}
+
+// delete marks the node with id as deleted and rebuilds the graph once half of it are tombstones.
+func (g *hnswGraph) delete(id string) bool {
+	for _, node := range g.nodes {
+		if node.id != id || node.deleted {
+			continue
+		}
+		node.deleted = true
+		g.deleted++
+		if g.deleted*2 > len(g.nodes) {
+			g.rebuild()
+		}
+		return true
+	}
+	return false
+}
+
+// rebuild reinserts the live nodes into an empty graph, dropping the tombstones.
+func (g *hnswGraph) rebuild() {
+	nodes := g.nodes
+	g.nodes = nil
+	g.entryPoint = -1
+	g.maxLevel = 0
+	g.deleted = 0
+	for _, node := range nodes {
+		if !node.deleted {
+			g.insert(node.id, node.properties, node.vector)
+		}
+	}
+}
+
+// candidateHeap is a min heap of candidates by distance, or a max heap when max is set.
+type candidateHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (h candidateHeap) Len() int { return len(h.items) }
+
+func (h candidateHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].distance > h.items[j].distance
+	}
+	return h.items[i].distance < h.items[j].distance
+}
+
+func (h candidateHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *candidateHeap) Push(x interface{}) { h.items = append(h.items, x.(hnswCandidate)) }
+
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
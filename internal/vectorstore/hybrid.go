@@ -0,0 +1,196 @@
+package vectorstore
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fusion is how the keyword and vector rankings of a hybrid search are combined.
+type Fusion string
+
+const (
+	// AlphaFusion scales the scores of both rankings to [0, 1] and sums them weighted by alpha.
+	AlphaFusion Fusion = "alpha"
+	// RRFFusion sums the reciprocal ranks of both rankings weighted by alpha, ignoring the scores.
+	RRFFusion Fusion = "rrf"
+)
+
+// DefaultAlpha weights keyword and vector similarity equally.
+const DefaultAlpha = 0.5
+
+// HybridProperties are the properties the keyword side of a hybrid search matches.
+var HybridProperties = []string{"content", "keywords", "questions"}
+
+// _rrfK dampens the weight of the first ranks in reciprocal rank fusion, 60 as in the original paper.
+const _rrfK = 60
+
+// HybridOptions configures a hybrid search.
+type HybridOptions struct {
+	// Alpha is the weight of the vector ranking, 1 is a pure vector search and 0 a pure keyword search.
+	Alpha float32
+	// Fusion is how both rankings are combined, AlphaFusion when empty.
+	Fusion Fusion
+}
+
+// WithHybrid combines a BM25 keyword search over HybridProperties with the vector search.
+// The input of SimilaritySearch is the keyword query.
+func WithHybrid(alpha float32, fusion Fusion) SearchOption {
+	return func(o *SearchOptions) {
+		o.Hybrid = &HybridOptions{Alpha: alpha, Fusion: fusion}
+	}
+}
+
+// hybridPoolSize is how many candidates each side of a hybrid search contributes to the fusion.
+func hybridPoolSize(limit int) int {
+	return maxInt(limit*4, 20)
+}
+
+// fuseRankings merges the vector and keyword rankings, both sorted best first, and returns the limit best objects.
+// The results keep the distance and vector of their candidates and get the fused score.
+func fuseRankings(vector, keyword []SearchResult, hybrid HybridOptions, limit int) []SearchResult {
+	alpha := float64(hybrid.Alpha)
+	fused := make(map[string]*SearchResult)
+	var order []string
+
+	add := func(ranking []SearchResult, weight float64) {
+		scores := make([]float64, len(ranking))
+		if hybrid.Fusion == RRFFusion {
+			for rank := range ranking {
+				scores[rank] = 1 / float64(_rrfK+rank+1)
+			}
+		} else {
+			scores = normalizeScores(ranking)
+		}
+
+		for i, obj := range ranking {
+			entry, ok := fused[obj.ID]
+			if !ok {
+				copied := obj
+				copied.Score = 0
+				entry = &copied
+				fused[obj.ID] = entry
+				order = append(order, obj.ID)
+			}
+			entry.Score += float32(weight * scores[i])
+		}
+	}
+	add(vector, alpha)
+	add(keyword, 1-alpha)
+
+	results := make([]SearchResult, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// normalizeScores min-max scales the scores of a ranking to [0, 1]. A single or uniform ranking scores 1.
+func normalizeScores(ranking []SearchResult) []float64 {
+	scores := make([]float64, len(ranking))
+	if len(ranking) == 0 {
+		return scores
+	}
+
+	min, max := float64(ranking[0].Score), float64(ranking[0].Score)
+	for _, obj := range ranking {
+		min = math.Min(min, float64(obj.Score))
+		max = math.Max(max, float64(obj.Score))
+	}
+	for i, obj := range ranking {
+		if max == min {
+			scores[i] = 1
+		} else {
+			scores[i] = (float64(obj.Score) - min) / (max - min)
+		}
+	}
+	return scores
+}
+
+// tokenize lowercases text and splits it into words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// keywordText concatenates the HybridProperties of an object, including the elements of array properties.
+func keywordText(properties map[string]interface{}) string {
+	var b strings.Builder
+	for _, name := range HybridProperties {
+		switch v := properties[name].(type) {
+		case string:
+			b.WriteString(v)
+			b.WriteByte(' ')
+		case []interface{}:
+			for _, element := range v {
+				if s, ok := element.(string); ok {
+					b.WriteString(s)
+					b.WriteByte(' ')
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// BM25 parameters, the usual defaults also used by Weaviate.
+const (
+	_bm25K1 = 1.2
+	_bm25B  = 0.75
+)
+
+// bm25Rank scores the candidates against query with BM25 over their keywordText and returns the matching ones, best first.
+// Document frequencies are computed over the candidates, so they should be the whole class or a superset of the matches.
+func bm25Rank(query string, candidates []SearchResult, limit int) []SearchResult {
+	terms := tokenize(query)
+	if len(terms) == 0 || len(candidates) == 0 {
+		return nil
+	}
+
+	documents := make([]map[string]int, len(candidates))
+	lengths := make([]int, len(candidates))
+	totalLength := 0
+	df := make(map[string]int)
+	for i, candidate := range candidates {
+		tokens := tokenize(keywordText(candidate.Properties))
+		documents[i] = make(map[string]int)
+		for _, token := range tokens {
+			documents[i][token]++
+		}
+		for token := range documents[i] {
+			df[token]++
+		}
+		lengths[i] = len(tokens)
+		totalLength += len(tokens)
+	}
+	avgLength := math.Max(float64(totalLength)/float64(len(candidates)), 1)
+	n := float64(len(candidates))
+
+	ranked := make([]SearchResult, 0)
+	for i, candidate := range candidates {
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(documents[i][term])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log(1 + (n-float64(df[term])+0.5)/(float64(df[term])+0.5))
+			score += idf * tf * (_bm25K1 + 1) / (tf + _bm25K1*(1-_bm25B+_bm25B*float64(lengths[i])/avgLength))
+		}
+		if score > 0 {
+			candidate.Score = float32(score)
+			ranked = append(ranked, candidate)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
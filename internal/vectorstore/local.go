@@ -0,0 +1,542 @@
+package vectorstore
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	_localFileMagic   = "intellichunk-hnsw\n"
+	_localFileVersion = 1
+
+	_defaultLockTimeout = 10 * time.Second
+	// A lock file without its holder older than this is considered left behind by a writer that crashed
+	// before writing it. The lock files of the writers of another host are never considered left behind.
+	_staleLockAge = time.Minute
+)
+
+// ErrStoreLocked is returned when another writer holds the store's lock for longer than the lock timeout.
+var ErrStoreLocked = errors.New("local vector store is locked by another writer")
+
+// LocalStore is a persistent, single file implementation of the VectorStore interface.
+// Every class is an HNSW graph whose nodes carry the object properties, so the whole index is
+// reopened from the file without rebuilding it.
+//
+// Any number of readers, in this or other processes, can use the file while a single writer updates it:
+// writers take a lock file next to the index, apply their change on top of the latest version of the file
+// and atomically replace it. Readers reload the file when it changed since they last read it.
+type LocalStore struct {
+	Path           string
+	Metric         DistanceMetric
+	M              int
+	EfConstruction int
+	EfSearch       int
+	LockTimeout    time.Duration
+
+	mu      sync.RWMutex
+	classes map[string]*hnswGraph
+	// info of the file when it was last read or written. Every write renames a new file over
+	// the index, so a different file means another writer changed it.
+	info os.FileInfo
+}
+
+var _ VectorStore = (*LocalStore)(nil)
+
+// LocalOption is a function that can modify the LocalStore configuration.
+type LocalOption func(*LocalStore)
+
+// WithLocalMetric sets the distance metric of a new index. An existing index keeps the metric it was created with.
+func WithLocalMetric(metric DistanceMetric) LocalOption {
+	return func(store *LocalStore) {
+		store.Metric = metric
+	}
+}
+
+// WithHNSW sets the maximum number of neighbors per node and the size of the dynamic candidate
+// lists used while building and searching the graph.
+func WithHNSW(m, efConstruction, efSearch int) LocalOption {
+	return func(store *LocalStore) {
+		store.M = m
+		store.EfConstruction = efConstruction
+		store.EfSearch = efSearch
+	}
+}
+
+// WithLockTimeout sets how long a writer waits for the lock held by another writer.
+func WithLockTimeout(timeout time.Duration) LocalOption {
+	return func(store *LocalStore) {
+		store.LockTimeout = timeout
+	}
+}
+
+// NewLocalStore opens the index at path, creating an empty one if the file doesn't exist yet.
+func NewLocalStore(path string, options ...LocalOption) (*LocalStore, error) {
+	store := &LocalStore{
+		Path:           path,
+		Metric:         Cosine,
+		M:              _defaultHNSWM,
+		EfConstruction: _defaultHNSWEfConstruction,
+		EfSearch:       _defaultHNSWEfSearch,
+		LockTimeout:    _defaultLockTimeout,
+		classes:        make(map[string]*hnswGraph),
+	}
+
+	for _, option := range options {
+		option(store)
+	}
+
+	if err := store.refresh(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// CheckAndCreateClass creates the class if it doesn't exist.
+func (store *LocalStore) CheckAndCreateClass(className string) error {
+	return store.update(func() error {
+		store.class(className)
+		return nil
+	})
+}
+
+// AddNodeObjects adds the ContainerNodeVector objects to the class, creating it if needed.
+func (store *LocalStore) AddNodeObjects(className string, objects []models.ContainerNodeVector) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, nodeProperties(obj))
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+// AddGenericObjects adds the GeneralDataHolder objects to the class, creating it if needed.
+func (store *LocalStore) AddGenericObjects(className string, objects []models.GeneralDataHolder) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, map[string]interface{}{
+			"title":   obj.Title,
+			"content": obj.Content,
+		})
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+func (store *LocalStore) addObjects(className string, properties []map[string]interface{}, vectors [][]float32) (objIDs []string, err error) {
+	err = store.update(func() error {
+		graph := store.class(className)
+		for i := range properties {
+			props, err := normalizeProperties(properties[i])
+			if err != nil {
+				return err
+			}
+			id := uuid.NewString()
+			graph.insert(id, props, append([]float32(nil), vectors[i]...))
+			objIDs = append(objIDs, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objIDs, nil
+}
+
+// DeleteObjectByID removes an object from the class.
+func (store *LocalStore) DeleteObjectByID(className, objectID string) (err error) {
+	return store.update(func() error {
+		graph, ok := store.classes[className]
+		if !ok {
+			return fmt.Errorf("class %q not found", className)
+		}
+		if !graph.delete(objectID) {
+			return fmt.Errorf("object %q not found in class %q", objectID, className)
+		}
+		return nil
+	})
+}
+
+// GetObjects returns up to withLimit objects of the class with the requested fields.
+func (store *LocalStore) GetObjects(className string, graphFieldNames []string, withLimit int) (interface{}, error) {
+	if err := store.refresh(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	graph, ok := store.classes[className]
+	if !ok {
+		return nil, fmt.Errorf("class %q not found", className)
+	}
+
+	objects := make([]map[string]interface{}, 0, withLimit)
+	for _, node := range graph.nodes {
+		if len(objects) == withLimit {
+			break
+		}
+		if !node.deleted {
+			objects = append(objects, selectFields(node.properties, graphFieldNames))
+		}
+	}
+	return objects, nil
+}
+
+// SimilaritySearch returns the withLimit objects closest to the query vector, which must be given with WithQueryVector.
+// With WithHybrid, input is also matched with BM25 against every live object of the class.
+func (store *LocalStore) SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int, opts ...SearchOption) ([]SearchResult, error) {
+	options := &SearchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if len(options.QueryVector) == 0 {
+		return nil, ErrQueryVectorRequired
+	}
+	if err := validateSearchFilter(options); err != nil {
+		return nil, err
+	}
+
+	if err := store.refresh(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	graph, ok := store.classes[className]
+	if !ok {
+		return nil, fmt.Errorf("class %q not found", className)
+	}
+
+	var match func(*hnswNode) bool
+	if options.Filter != nil {
+		match = func(node *hnswNode) bool {
+			return matchFilter(options.Filter, node.properties)
+		}
+	}
+
+	result := func(index int, distance float32) SearchResult {
+		node := graph.nodes[index]
+		return SearchResult{
+			ID:         node.id,
+			Distance:   distance,
+			Score:      graph.metric.Similarity(distance),
+			Properties: node.properties,
+			Vector:     node.vector,
+		}
+	}
+
+	limit := withLimit
+	if options.Hybrid != nil {
+		limit = hybridPoolSize(withLimit)
+	}
+	var results []SearchResult
+	for _, c := range graph.search(options.QueryVector, limit, match) {
+		results = append(results, result(c.index, c.distance))
+	}
+	results = withinMaxDistance(results, options)
+
+	if options.Hybrid != nil {
+		// The graph only helps the vector side, keywords are matched against every live node.
+		all := make([]SearchResult, 0, graph.len())
+		for index, node := range graph.nodes {
+			if node.deleted || (match != nil && !match(node)) {
+				continue
+			}
+			all = append(all, result(index, graph.distance(options.QueryVector, index)))
+		}
+		// MaxDistance only applies to the vector ranking, an exact keyword match may be far from the query.
+		results = fuseRankings(results, bm25Rank(input, all, limit), *options.Hybrid, withLimit)
+	}
+
+	return finishResults(results, graphFieldNames, options), nil
+}
+
+// class returns the graph of className, creating it if needed. The caller must hold the write lock.
+func (store *LocalStore) class(className string) *hnswGraph {
+	graph, ok := store.classes[className]
+	if !ok {
+		graph = newHNSWGraph(store.Metric, store.M, store.EfConstruction, store.EfSearch)
+		store.classes[className] = graph
+	}
+	return graph
+}
+
+// update applies fn on top of the latest version of the file while holding the writer lock, then saves it.
+func (store *LocalStore) update(fn func() error) error {
+	unlock, err := store.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.reloadIfChanged(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return store.save()
+}
+
+// refresh reloads the file if another writer changed it. The readers only take the write lock to reload it.
+func (store *LocalStore) refresh() error {
+	store.mu.RLock()
+	_, changed, err := store.changed()
+	store.mu.RUnlock()
+	if err != nil || !changed {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.reloadIfChanged()
+}
+
+// changed tells whether the file changed since it was last read or written, and returns its info. The caller must hold
+// the read lock.
+func (store *LocalStore) changed() (os.FileInfo, bool, error) {
+	info, err := os.Stat(store.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to stat local vector store: %w", err)
+	}
+
+	return info, store.info == nil || !os.SameFile(store.info, info) || !info.ModTime().Equal(store.info.ModTime()), nil
+}
+
+// reloadIfChanged reads the file if it changed since it was last read or written. The caller must hold the write lock.
+func (store *LocalStore) reloadIfChanged() error {
+	info, changed, err := store.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	if err := store.load(); err != nil {
+		return err
+	}
+	store.info = info
+	return nil
+}
+
+// lockFile creates the lock file of the index, waiting up to LockTimeout for another writer to release it.
+// The lock file holds the host and process id of its writer, so the lock of a writer that exited without
+// releasing it is taken over.
+func (store *LocalStore) lockFile() (unlock func(), err error) {
+	lockPath := store.Path + ".lock"
+	deadline := time.Now().Add(store.LockTimeout)
+	host, _ := os.Hostname()
+
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%s %d\n", host, os.Getpid())
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, fmt.Errorf("failed to lock local vector store: %w", err)
+			}
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock local vector store: %w", err)
+		}
+
+		if holder, abandoned := abandonedLock(lockPath, host); abandoned {
+			// Another waiter may have taken the lock over already, only the lock of the exited writer is removed.
+			if current, err := os.ReadFile(lockPath); err == nil && string(current) == holder {
+				os.Remove(lockPath)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrStoreLocked
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// abandonedLock reads the lock file and tells whether its writer exited without releasing it.
+// Only the writers of this host can be checked, a lock file without a writer is abandoned once older than _staleLockAge.
+func abandonedLock(lockPath, host string) (holder string, abandoned bool) {
+	content, err := os.ReadFile(lockPath)
+	if err != nil {
+		return "", false
+	}
+	holder = string(content)
+
+	var lockHost string
+	var pid int
+	if _, err := fmt.Sscanf(holder, "%s %d\n", &lockHost, &pid); err != nil {
+		// The writer may not have written it yet.
+		info, err := os.Stat(lockPath)
+		return holder, err == nil && time.Since(info.ModTime()) > _staleLockAge
+	}
+	return holder, lockHost == host && !processAlive(pid)
+}
+
+// localFile is the on disk representation of the index.
+type localFile struct {
+	Version        int
+	Metric         DistanceMetric
+	M              int
+	EfConstruction int
+	EfSearch       int
+	Classes        map[string]localGraph
+}
+
+type localGraph struct {
+	Nodes      []localNode
+	EntryPoint int
+	MaxLevel   int
+	Deleted    int
+}
+
+type localNode struct {
+	ID string
+	// Properties are JSON encoded since gob can't encode arbitrary interface values.
+	Properties []byte
+	Vector     []float32
+	Neighbors  [][]int
+	Deleted    bool
+}
+
+// save writes the index to a temporary file and renames it over the index. The caller must hold the write lock.
+func (store *LocalStore) save() error {
+	file := localFile{
+		Version:        _localFileVersion,
+		Metric:         store.Metric,
+		M:              store.M,
+		EfConstruction: store.EfConstruction,
+		EfSearch:       store.EfSearch,
+		Classes:        make(map[string]localGraph, len(store.classes)),
+	}
+	for className, graph := range store.classes {
+		lg := localGraph{
+			Nodes:      make([]localNode, 0, len(graph.nodes)),
+			EntryPoint: graph.entryPoint,
+			MaxLevel:   graph.maxLevel,
+			Deleted:    graph.deleted,
+		}
+		for _, node := range graph.nodes {
+			properties, err := json.Marshal(node.properties)
+			if err != nil {
+				return fmt.Errorf("failed to encode properties of %s: %w", node.id, err)
+			}
+			lg.Nodes = append(lg.Nodes, localNode{
+				ID:         node.id,
+				Properties: properties,
+				Vector:     node.vector,
+				Neighbors:  node.neighbors,
+				Deleted:    node.deleted,
+			})
+		}
+		file.Classes[className] = lg
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.Path), filepath.Base(store.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create local vector store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if _, err := io.WriteString(w, _localFileMagic); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write local vector store: %w", err)
+	}
+	if err := gob.NewEncoder(w).Encode(file); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode local vector store: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write local vector store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write local vector store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), store.Path); err != nil {
+		return fmt.Errorf("failed to replace local vector store: %w", err)
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat local vector store: %w", err)
+	}
+	store.info = info
+	return nil
+}
+
+// load replaces the in memory index with the content of the file. The caller must hold the write lock.
+func (store *LocalStore) load() error {
+	f, err := os.Open(store.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open local vector store: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(_localFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != _localFileMagic {
+		return fmt.Errorf("%s is not a local vector store", store.Path)
+	}
+
+	var file localFile
+	if err := gob.NewDecoder(r).Decode(&file); err != nil {
+		return fmt.Errorf("failed to decode local vector store: %w", err)
+	}
+	if file.Version != _localFileVersion {
+		return fmt.Errorf("unsupported local vector store version %d", file.Version)
+	}
+
+	store.Metric = file.Metric
+	store.M, store.EfConstruction, store.EfSearch = file.M, file.EfConstruction, file.EfSearch
+
+	classes := make(map[string]*hnswGraph, len(file.Classes))
+	for className, lg := range file.Classes {
+		graph := newHNSWGraph(store.Metric, store.M, store.EfConstruction, store.EfSearch)
+		graph.entryPoint, graph.maxLevel, graph.deleted = lg.EntryPoint, lg.MaxLevel, lg.Deleted
+		graph.nodes = make([]*hnswNode, 0, len(lg.Nodes))
+		for _, ln := range lg.Nodes {
+			properties := make(map[string]interface{})
+			if err := json.Unmarshal(ln.Properties, &properties); err != nil {
+				return fmt.Errorf("failed to decode properties of %s: %w", ln.ID, err)
+			}
+			neighbors := ln.Neighbors
+			if neighbors == nil {
+				neighbors = make([][]int, 1)
+			}
+			graph.nodes = append(graph.nodes, &hnswNode{
+				id:         ln.ID,
+				properties: properties,
+				vector:     ln.Vector,
+				neighbors:  neighbors,
+				deleted:    ln.Deleted,
+			})
+		}
+		classes[className] = graph
+	}
+	store.classes = classes
+	return nil
+}
@@ -0,0 +1,418 @@
+package vectorstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/google/uuid"
+)
+
+// ErrQueryVectorRequired is returned by stores that can't vectorize the input themselves.
+var ErrQueryVectorRequired = errors.New("similarity search requires a query vector, use WithQueryVector")
+
+// _minJournalEntries is the number of writes journaled before the snapshot is compacted, at least.
+const _minJournalEntries = 1000
+
+// MemoryStore is a pure Go, in process implementation of the VectorStore interface.
+// Classes are namespaces of objects holding the node properties and their vector.
+// When a snapshot path is set, the store is loaded from it, and every write is appended to a journal next to it
+// instead of rewriting the whole snapshot. The journal is compacted into the snapshot once it has more entries
+// than the store has objects.
+type MemoryStore struct {
+	Metric       DistanceMetric
+	SnapshotPath string
+
+	mu      sync.RWMutex
+	classes map[string]*memoryClass
+	// journaled is the number of entries of the journal.
+	journaled int
+}
+
+// memoryEntry is a write of the journal: a class created, an object added to it or the id of an object deleted.
+type memoryEntry struct {
+	Class  string        `json:"class"`
+	Object *memoryObject `json:"object,omitempty"`
+	Delete string        `json:"delete,omitempty"`
+}
+
+// memoryClass keeps the objects of a class in insertion order.
+type memoryClass struct {
+	Objects []*memoryObject `json:"objects"`
+}
+
+type memoryObject struct {
+	ID         string                 `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+	Vector     []float32              `json:"vector"`
+}
+
+var _ VectorStore = (*MemoryStore)(nil)
+
+// MemoryOption is a function that can modify the MemoryStore configuration.
+type MemoryOption func(*MemoryStore)
+
+// WithMetric sets the distance metric used by SimilaritySearch.
+func WithMetric(metric DistanceMetric) MemoryOption {
+	return func(store *MemoryStore) {
+		store.Metric = metric
+	}
+}
+
+// WithSnapshotPath loads the store from path if it exists and journals every write next to it.
+func WithSnapshotPath(path string) MemoryOption {
+	return func(store *MemoryStore) {
+		store.SnapshotPath = path
+	}
+}
+
+// NewMemoryStore creates a new, empty MemoryStore or loads it from its snapshot.
+func NewMemoryStore(options ...MemoryOption) (*MemoryStore, error) {
+	store := &MemoryStore{
+		Metric:  Cosine,
+		classes: make(map[string]*memoryClass),
+	}
+
+	for _, option := range options {
+		option(store)
+	}
+
+	if store.SnapshotPath != "" {
+		if err := store.load(); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// CheckAndCreateClass creates the class namespace if it doesn't exist.
+func (store *MemoryStore) CheckAndCreateClass(className string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.classes[className]; ok {
+		return nil
+	}
+	store.classes[className] = &memoryClass{}
+	return store.save(memoryEntry{Class: className})
+}
+
+// AddNodeObjects adds the ContainerNodeVector objects to the class, creating it if needed.
+func (store *MemoryStore) AddNodeObjects(className string, objects []models.ContainerNodeVector) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, nodeProperties(obj))
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+// AddGenericObjects adds the GeneralDataHolder objects to the class, creating it if needed.
+func (store *MemoryStore) AddGenericObjects(className string, objects []models.GeneralDataHolder) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, map[string]interface{}{
+			"title":   obj.Title,
+			"content": obj.Content,
+		})
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+func (store *MemoryStore) addObjects(className string, properties []map[string]interface{}, vectors [][]float32) (objIDs []string, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	class, ok := store.classes[className]
+	if !ok {
+		class = &memoryClass{}
+		store.classes[className] = class
+	}
+
+	entries := make([]memoryEntry, 0, len(properties))
+	if !ok {
+		entries = append(entries, memoryEntry{Class: className})
+	}
+	for i := range properties {
+		// Round trip the properties through JSON so they have the same types as after loading a snapshot.
+		props, err := normalizeProperties(properties[i])
+		if err != nil {
+			return objIDs, err
+		}
+		obj := &memoryObject{
+			ID:         uuid.NewString(),
+			Properties: props,
+			Vector:     append([]float32(nil), vectors[i]...),
+		}
+		class.Objects = append(class.Objects, obj)
+		objIDs = append(objIDs, obj.ID)
+		entries = append(entries, memoryEntry{Class: className, Object: obj})
+	}
+
+	return objIDs, store.save(entries...)
+}
+
+// DeleteObjectByID removes an object from the class.
+func (store *MemoryStore) DeleteObjectByID(className, objectID string) (err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	class, ok := store.classes[className]
+	if !ok {
+		return fmt.Errorf("class %q not found", className)
+	}
+	for i, obj := range class.Objects {
+		if obj.ID == objectID {
+			class.Objects = append(class.Objects[:i], class.Objects[i+1:]...)
+			return store.save(memoryEntry{Class: className, Delete: objectID})
+		}
+	}
+	return fmt.Errorf("object %q not found in class %q", objectID, className)
+}
+
+// GetObjects returns up to withLimit objects of the class with the requested fields.
+func (store *MemoryStore) GetObjects(className string, graphFieldNames []string, withLimit int) (interface{}, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	class, ok := store.classes[className]
+	if !ok {
+		return nil, fmt.Errorf("class %q not found", className)
+	}
+
+	objects := make([]map[string]interface{}, 0, withLimit)
+	for _, obj := range class.Objects {
+		if len(objects) == withLimit {
+			break
+		}
+		objects = append(objects, selectFields(obj.Properties, graphFieldNames))
+	}
+	return objects, nil
+}
+
+// SimilaritySearch returns the withLimit objects closest to the query vector, which must be given with WithQueryVector.
+// With WithHybrid, input is also matched with BM25 against every object of the class.
+func (store *MemoryStore) SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int, opts ...SearchOption) ([]SearchResult, error) {
+	options := &SearchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if len(options.QueryVector) == 0 {
+		return nil, ErrQueryVectorRequired
+	}
+	if err := validateSearchFilter(options); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	class, ok := store.classes[className]
+	if !ok {
+		return nil, fmt.Errorf("class %q not found", className)
+	}
+
+	candidates := make([]SearchResult, 0, len(class.Objects))
+	for _, obj := range class.Objects {
+		if !matchFilter(options.Filter, obj.Properties) {
+			continue
+		}
+		distance := store.Metric.Distance(options.QueryVector, obj.Vector)
+		candidates = append(candidates, SearchResult{
+			ID:         obj.ID,
+			Distance:   distance,
+			Score:      store.Metric.Similarity(distance),
+			Properties: obj.Properties,
+			Vector:     obj.Vector,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Distance < candidates[j].Distance })
+	near := withinMaxDistance(candidates, options)
+
+	var results []SearchResult
+	if options.Hybrid != nil {
+		// The keywords are ranked over the whole filtered class, MaxDistance only applies to the vector ranking.
+		pool := hybridPoolSize(withLimit)
+		vector := near[:minInt(pool, len(near))]
+		results = fuseRankings(vector, bm25Rank(input, candidates, pool), *options.Hybrid, withLimit)
+	} else {
+		results = near[:minInt(withLimit, len(near))]
+	}
+
+	return finishResults(results, graphFieldNames, options), nil
+}
+
+// Snapshot writes the whole store to path.
+func (store *MemoryStore) Snapshot(path string) error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.writeSnapshot(path)
+}
+
+// save appends the entries to the journal if a snapshot path is set, and compacts the journal into the snapshot
+// once it outgrows the store. The caller must hold the lock.
+func (store *MemoryStore) save(entries ...memoryEntry) error {
+	if store.SnapshotPath == "" {
+		return nil
+	}
+
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal entry: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	journal, err := os.OpenFile(store.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if _, err := journal.Write(data); err != nil {
+		journal.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := journal.Close(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	store.journaled += len(entries)
+
+	objects := 0
+	for _, class := range store.classes {
+		objects += len(class.Objects)
+	}
+	if store.journaled < _minJournalEntries || store.journaled <= objects {
+		return nil
+	}
+	return store.compact()
+}
+
+// compact writes the snapshot and empties the journal. Replaying the journal is idempotent, so a crash in between
+// loads the same store. The caller must hold the lock.
+func (store *MemoryStore) compact() error {
+	if err := store.writeSnapshot(store.SnapshotPath); err != nil {
+		return err
+	}
+	if err := os.Remove(store.journalPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to empty journal: %w", err)
+	}
+	store.journaled = 0
+	return nil
+}
+
+// journalPath is the journal of the writes since the snapshot was written.
+func (store *MemoryStore) journalPath() string {
+	return store.SnapshotPath + ".journal"
+}
+
+// writeSnapshot encodes the classes to a temporary file and renames it, so a crash never leaves a partial snapshot.
+func (store *MemoryStore) writeSnapshot(path string) error {
+	data, err := json.Marshal(store.classes)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load reads the snapshot and replays the journal, a missing snapshot is an empty store.
+func (store *MemoryStore) load() error {
+	data, err := os.ReadFile(store.SnapshotPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	} else if err == nil {
+		if err := json.Unmarshal(data, &store.classes); err != nil {
+			return fmt.Errorf("failed to decode snapshot %s: %w", store.SnapshotPath, err)
+		}
+	}
+
+	journal, err := os.Open(store.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	defer journal.Close()
+
+	decoder := json.NewDecoder(journal)
+	for {
+		var entry memoryEntry
+		if err := decoder.Decode(&entry); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			// The last entry is cut when the process stopped while writing it, the write failed. The journal is
+			// compacted so the next entries aren't appended to it.
+			log.Warningf("Ignoring the end of the journal %s: %v", store.journalPath(), err)
+			return store.compact()
+		}
+		store.replay(entry)
+		store.journaled++
+	}
+}
+
+// replay applies an entry of the journal, the entries already in the snapshot change nothing.
+func (store *MemoryStore) replay(entry memoryEntry) {
+	class, ok := store.classes[entry.Class]
+	if !ok {
+		class = &memoryClass{}
+		store.classes[entry.Class] = class
+	}
+	for i, obj := range class.Objects {
+		switch {
+		case entry.Object != nil && obj.ID == entry.Object.ID:
+			return
+		case entry.Delete != "" && obj.ID == entry.Delete:
+			class.Objects = append(class.Objects[:i], class.Objects[i+1:]...)
+			return
+		}
+	}
+	if entry.Object != nil {
+		class.Objects = append(class.Objects, entry.Object)
+	}
+}
+
+// normalizeProperties converts the property values to the types JSON decoding produces.
+func normalizeProperties(properties map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[string]interface{})
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+// selectFields returns the requested properties, missing ones are left out like Weaviate does.
+func selectFields(properties map[string]interface{}, graphFieldNames []string) map[string]interface{} {
+	object := make(map[string]interface{}, len(graphFieldNames))
+	for _, fieldName := range graphFieldNames {
+		if value, ok := properties[fieldName]; ok {
+			object[fieldName] = value
+		}
+	}
+	return object
+}
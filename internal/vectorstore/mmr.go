@@ -0,0 +1,73 @@
+package vectorstore
+
+// MMR selects k of the candidates with maximal marginal relevance, trading their relevance for diversity.
+// Each step picks the candidate maximizing lambda * relevance - (1 - lambda) * similarity to the already selected ones,
+// so lambda 1 keeps the ranking and lambda 0 only diversifies.
+//
+// Relevance is the Score of the candidates scaled to [0, 1], so it works on similarity, hybrid and reranked results alike.
+// Similarity is the cosine similarity of their vectors, which must be returned with WithVectors.
+// Candidates without vectors are never considered similar to the others. k is clamped between 0 and the number of candidates.
+func MMR(candidates []SearchResult, k int, lambda float32) []SearchResult {
+	if k >= len(candidates) {
+		k = len(candidates)
+	}
+	if k < 0 {
+		k = 0
+	}
+	relevance := mmrRelevance(candidates)
+
+	selected := make([]SearchResult, 0, k)
+	used := make([]bool, len(candidates))
+	// maxSimilarity is the highest similarity of each candidate to the selected ones.
+	maxSimilarity := make([]float64, len(candidates))
+
+	for len(selected) < k {
+		best, bestScore := -1, 0.0
+		for i := range candidates {
+			if used[i] {
+				continue
+			}
+			score := float64(lambda)*relevance[i] - float64(1-lambda)*maxSimilarity[i]
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		used[best] = true
+		selected = append(selected, candidates[best])
+		for i := range candidates {
+			if used[i] || len(candidates[i].Vector) == 0 || len(candidates[best].Vector) == 0 {
+				continue
+			}
+			similarity := float64(Cosine.Similarity(Cosine.Distance(candidates[i].Vector, candidates[best].Vector)))
+			if similarity > maxSimilarity[i] {
+				maxSimilarity[i] = similarity
+			}
+		}
+	}
+	return selected
+}
+
+// mmrRelevance scales the scores of the candidates between 0, or the lowest score if negative, and the highest score.
+// Unlike min-max scaling, the least relevant candidate of positive scores keeps its relevance.
+func mmrRelevance(candidates []SearchResult) []float64 {
+	relevance := make([]float64, len(candidates))
+	low, high := 0.0, 0.0
+	for i, candidate := range candidates {
+		score := float64(candidate.Score)
+		if i == 0 || score > high {
+			high = score
+		}
+		if score < low {
+			low = score
+		}
+	}
+	for i, candidate := range candidates {
+		if high > low {
+			relevance[i] = (float64(candidate.Score) - low) / (high - low)
+		} else {
+			relevance[i] = 1
+		}
+	}
+	return relevance
+}
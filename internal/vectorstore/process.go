@@ -0,0 +1,19 @@
+//go:build !windows
+
+package vectorstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processAlive tells whether the process runs, signal 0 only checks it exists.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
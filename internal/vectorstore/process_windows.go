@@ -0,0 +1,13 @@
+package vectorstore
+
+import "os"
+
+// processAlive tells whether the process runs, Windows only finds the processes that run.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
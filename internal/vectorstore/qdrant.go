@@ -0,0 +1,461 @@
+package vectorstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/apsystole/log"
+	"github.com/cckalen/intellichunk/internal/models"
+	"github.com/google/uuid"
+)
+
+const _defaultQdrantURL = "http://localhost:6333"
+
+// errQdrantNotFound is returned by do when Qdrant answers 404.
+var errQdrantNotFound = errors.New("qdrant: not found")
+
+// QdrantStore is a Qdrant implementation of the VectorStore interface using its REST API.
+// Every class is a collection and the node properties are stored as the point payload.
+// Collections are created on first insert, when the size of the vectors is known.
+type QdrantStore struct {
+	URL    string
+	APIKey string
+	Metric DistanceMetric
+	// Dimensions of the vectors, used by CheckAndCreateClass. When zero collections are created on first insert.
+	Dimensions int
+	HTTPClient *http.Client
+
+	collections sync.Map
+}
+
+var _ VectorStore = (*QdrantStore)(nil)
+
+// QdrantOption is a function that can modify the QdrantStore configuration.
+type QdrantOption func(*QdrantStore)
+
+// WithQdrantURL sets the base URL of the Qdrant REST API.
+func WithQdrantURL(url string) QdrantOption {
+	return func(store *QdrantStore) {
+		store.URL = url
+	}
+}
+
+// WithQdrantAPIKey sets the API key sent to Qdrant.
+func WithQdrantAPIKey(key string) QdrantOption {
+	return func(store *QdrantStore) {
+		store.APIKey = key
+	}
+}
+
+// WithQdrantMetric sets the distance of the collections created by the store.
+func WithQdrantMetric(metric DistanceMetric) QdrantOption {
+	return func(store *QdrantStore) {
+		store.Metric = metric
+	}
+}
+
+// WithQdrantDimensions sets the size of the vectors so CheckAndCreateClass can create collections.
+func WithQdrantDimensions(dimensions int) QdrantOption {
+	return func(store *QdrantStore) {
+		store.Dimensions = dimensions
+	}
+}
+
+// NewQdrantStore creates a new QdrantStore configured from QDRANT_URL and QDRANT_API_KEY and the options.
+func NewQdrantStore(options ...QdrantOption) *QdrantStore {
+	store := &QdrantStore{
+		URL:        os.Getenv("QDRANT_URL"),
+		APIKey:     os.Getenv("QDRANT_API_KEY"),
+		Metric:     Cosine,
+		HTTPClient: http.DefaultClient,
+	}
+	if store.URL == "" {
+		store.URL = _defaultQdrantURL
+	}
+
+	for _, option := range options {
+		option(store)
+	}
+
+	return store
+}
+
+// qdrantDistance returns the Qdrant name of the metric.
+func (store *QdrantStore) qdrantDistance() string {
+	switch store.Metric {
+	case Dot:
+		return "Dot"
+	case L2Squared:
+		return "Euclid"
+	default:
+		return "Cosine"
+	}
+}
+
+// do sends a request to the Qdrant REST API and decodes the "result" field of the response into result.
+func (store *QdrantStore) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, strings.TrimSuffix(store.URL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if store.APIKey != "" {
+		req.Header.Set("api-key", store.APIKey)
+	}
+
+	resp, err := store.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errQdrantNotFound
+	}
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Status interface{}     `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("qdrant: failed to decode response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("qdrant: %s %s: %s: %v", method, path, resp.Status, envelope.Status)
+	}
+	if result != nil && len(envelope.Result) > 0 {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
+
+func collectionPath(className string) string {
+	return "/collections/" + url.PathEscape(className)
+}
+
+// CheckAndCreateClass checks if the collection exists and creates it when the vector size is configured.
+func (store *QdrantStore) CheckAndCreateClass(className string) error {
+	return store.ensureCollection(className, store.Dimensions)
+}
+
+// ensureCollection creates the collection for vectors of size if it doesn't exist.
+func (store *QdrantStore) ensureCollection(className string, size int) error {
+	if _, ok := store.collections.Load(className); ok {
+		return nil
+	}
+
+	err := store.do(http.MethodGet, collectionPath(className), nil, nil)
+	if err == nil {
+		store.collections.Store(className, struct{}{})
+		return nil
+	} else if !errors.Is(err, errQdrantNotFound) {
+		return err
+	}
+
+	if size == 0 {
+		// Created on first insert.
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"vectors": map[string]interface{}{
+			"size":     size,
+			"distance": store.qdrantDistance(),
+		},
+	}
+	if err := store.do(http.MethodPut, collectionPath(className), body, nil); err != nil {
+		log.Errorf("Failed to create Qdrant collection %s: %v", className, err)
+		return err
+	}
+	// Full text indexes make the keyword side of hybrid searches match words instead of substrings.
+	for _, name := range HybridProperties {
+		index := map[string]interface{}{"field_name": name, "field_schema": "text"}
+		if err := store.do(http.MethodPut, collectionPath(className)+"/index?wait=true", index, nil); err != nil {
+			log.Errorf("Failed to create Qdrant text index %s on %s: %v", name, className, err)
+			return err
+		}
+	}
+	store.collections.Store(className, struct{}{})
+	return nil
+}
+
+// AddNodeObjects upserts the ContainerNodeVector objects in a single batch.
+func (store *QdrantStore) AddNodeObjects(className string, objects []models.ContainerNodeVector) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, nodeProperties(obj))
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+// AddGenericObjects upserts the GeneralDataHolder objects in a single batch.
+func (store *QdrantStore) AddGenericObjects(className string, objects []models.GeneralDataHolder) (objIDs []string, err error) {
+	properties := make([]map[string]interface{}, 0, len(objects))
+	vectors := make([][]float32, 0, len(objects))
+	for _, obj := range objects {
+		properties = append(properties, map[string]interface{}{
+			"title":   obj.Title,
+			"content": obj.Content,
+		})
+		vectors = append(vectors, obj.Embedding)
+	}
+	return store.addObjects(className, properties, vectors)
+}
+
+type qdrantPoint struct {
+	ID      string                 `json:"id"`
+	Vector  []float32              `json:"vector,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	Score   float32                `json:"score,omitempty"`
+}
+
+func (store *QdrantStore) addObjects(className string, properties []map[string]interface{}, vectors [][]float32) (objIDs []string, err error) {
+	if len(vectors) == 0 {
+		return objIDs, nil
+	}
+	if err := store.ensureCollection(className, len(vectors[0])); err != nil {
+		return objIDs, err
+	}
+
+	points := make([]qdrantPoint, 0, len(properties))
+	ids := make([]string, 0, len(properties))
+	for i := range properties {
+		id := uuid.NewString()
+		points = append(points, qdrantPoint{ID: id, Vector: vectors[i], Payload: properties[i]})
+		ids = append(ids, id)
+	}
+
+	err = store.do(http.MethodPut, collectionPath(className)+"/points?wait=true", map[string]interface{}{"points": points}, nil)
+	if err != nil {
+		log.Errorf("Failed to add objects to Qdrant: %v", err)
+		return objIDs, err
+	}
+	return ids, nil
+}
+
+// DeleteObjectByID deletes a point from the collection.
+func (store *QdrantStore) DeleteObjectByID(className, objectID string) (err error) {
+	// Qdrant deletes missing points without an error, like the other stores a missing object fails.
+	var points []qdrantPoint
+	err = store.do(http.MethodPost, collectionPath(className)+"/points", map[string]interface{}{"ids": []string{objectID}}, &points)
+	if errors.Is(err, errQdrantNotFound) || (err == nil && len(points) == 0) {
+		return fmt.Errorf("object %q not found in class %q", objectID, className)
+	} else if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{"points": []string{objectID}}
+	return store.do(http.MethodPost, collectionPath(className)+"/points/delete?wait=true", body, nil)
+}
+
+// GetObjects returns up to withLimit objects of the collection with the requested fields.
+func (store *QdrantStore) GetObjects(className string, graphFieldNames []string, withLimit int) (interface{}, error) {
+	var result struct {
+		Points []qdrantPoint `json:"points"`
+	}
+	body := map[string]interface{}{"limit": withLimit, "with_payload": true}
+	if err := store.do(http.MethodPost, collectionPath(className)+"/points/scroll", body, &result); err != nil {
+		return nil, err
+	}
+
+	objects := make([]map[string]interface{}, 0, len(result.Points))
+	for _, point := range result.Points {
+		objects = append(objects, selectFields(point.Payload, graphFieldNames))
+	}
+	return objects, nil
+}
+
+// SimilaritySearch returns the withLimit points closest to the query vector, which must be given with WithQueryVector.
+// With WithHybrid, the points containing words of input are scored with BM25 in the client.
+func (store *QdrantStore) SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int, opts ...SearchOption) ([]SearchResult, error) {
+	options := &SearchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if len(options.QueryVector) == 0 {
+		return nil, ErrQueryVectorRequired
+	}
+	if err := validateSearchFilter(options); err != nil {
+		return nil, err
+	}
+
+	limit := withLimit
+	if options.Hybrid != nil {
+		limit = hybridPoolSize(withLimit)
+	}
+
+	body := map[string]interface{}{
+		"vector":       options.QueryVector,
+		"limit":        limit,
+		"with_payload": true,
+		"with_vector":  options.Vectors,
+	}
+	if options.Filter != nil {
+		body["filter"] = map[string]interface{}{"must": []interface{}{qdrantCondition(*options.Filter)}}
+	}
+
+	var points []qdrantPoint
+	if err := store.do(http.MethodPost, collectionPath(className)+"/points/search", body, &points); err != nil {
+		log.Errorf("Failed to perform Qdrant search: %v", err)
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(points))
+	for _, point := range points {
+		distance := store.distance(point.Score)
+		results = append(results, SearchResult{
+			ID:         point.ID,
+			Distance:   distance,
+			Score:      store.Metric.Similarity(distance),
+			Properties: point.Payload,
+			Vector:     point.Vector,
+		})
+	}
+	results = withinMaxDistance(results, options)
+
+	if options.Hybrid != nil {
+		keyword, err := store.keywordSearch(className, input, options, limit)
+		if err != nil {
+			log.Errorf("Failed to perform Qdrant keyword search: %v", err)
+			return nil, err
+		}
+		results = fuseRankings(results, keyword, *options.Hybrid, withLimit)
+	}
+
+	return finishResults(results, graphFieldNames, options), nil
+}
+
+// distance converts a Qdrant score to a distance of the store metric.
+// Qdrant scores Cosine and Dot with the similarity, and Euclid with the distance, which isn't squared.
+func (store *QdrantStore) distance(score float32) float32 {
+	switch store.Metric {
+	case Dot:
+		return -score
+	case L2Squared:
+		return score * score
+	default:
+		return 1 - score
+	}
+}
+
+// _qdrantScrollPage is the number of points of a page of a scroll.
+const _qdrantScrollPage = 256
+
+// keywordSearch ranks the points of the collection with BM25. Qdrant doesn't rank full text matches, so every point
+// matching the filter is scrolled without its vector, like the other stores rank over the whole filtered class.
+// Only the vectors of the ranked points are fetched, to compute their distances.
+func (store *QdrantStore) keywordSearch(className, input string, options *SearchOptions, limit int) ([]SearchResult, error) {
+	if len(tokenize(input)) == 0 {
+		return nil, nil
+	}
+
+	body := map[string]interface{}{"limit": _qdrantScrollPage, "with_payload": true, "with_vector": false}
+	if options.Filter != nil {
+		body["filter"] = map[string]interface{}{"must": []interface{}{qdrantCondition(*options.Filter)}}
+	}
+	var candidates []SearchResult
+	for {
+		var page struct {
+			Points []qdrantPoint `json:"points"`
+			Next   interface{}   `json:"next_page_offset"`
+		}
+		if err := store.do(http.MethodPost, collectionPath(className)+"/points/scroll", body, &page); err != nil {
+			return nil, err
+		}
+		for _, point := range page.Points {
+			candidates = append(candidates, SearchResult{ID: point.ID, Properties: point.Payload})
+		}
+		if page.Next == nil {
+			break
+		}
+		body["offset"] = page.Next
+	}
+
+	ranked := bm25Rank(input, candidates, limit)
+	if len(ranked) == 0 {
+		return ranked, nil
+	}
+	ids := make([]string, 0, len(ranked))
+	for _, result := range ranked {
+		ids = append(ids, result.ID)
+	}
+	var points []qdrantPoint
+	if err := store.do(http.MethodPost, collectionPath(className)+"/points", map[string]interface{}{"ids": ids, "with_vector": true}, &points); err != nil {
+		return nil, err
+	}
+	vectors := make(map[string][]float32, len(points))
+	for _, point := range points {
+		vectors[point.ID] = point.Vector
+	}
+	for i := range ranked {
+		ranked[i].Vector = vectors[ranked[i].ID]
+		ranked[i].Distance = store.Metric.Distance(options.QueryVector, ranked[i].Vector)
+	}
+	// MaxDistance only applies to the vector ranking, an exact keyword match may be far from the query.
+	return ranked, nil
+}
+
+// qdrantCondition translates a validated filter to a Qdrant filter condition.
+// Boolean operators become nested filters, which Qdrant accepts as conditions.
+func qdrantCondition(f models.Filter) map[string]interface{} {
+	switch f.Operator {
+	case models.FilterEq:
+		// Qdrant only matches keywords, integers and booleans exactly, so numbers are compared with a closed range.
+		if n, ok := toFloat(f.Value); ok {
+			return map[string]interface{}{"key": f.Property, "range": map[string]interface{}{"gte": n, "lte": n}}
+		}
+		return map[string]interface{}{"key": f.Property, "match": map[string]interface{}{"value": f.Value}}
+	case models.FilterIn:
+		operands := make([]models.Filter, 0, len(f.Values))
+		for _, value := range f.Values {
+			operands = append(operands, models.Eq(f.Property, value))
+		}
+		return qdrantCondition(models.Or(operands...))
+	case models.FilterRange:
+		bounds := map[string]interface{}{}
+		if f.Min != nil {
+			bounds["gte"] = f.Min
+		}
+		if f.Max != nil {
+			bounds["lte"] = f.Max
+		}
+		rangeType := "range"
+		if _, ok := toTime(f.Min); ok {
+			rangeType = "datetime_range"
+		} else if _, ok := toTime(f.Max); ok {
+			rangeType = "datetime_range"
+		}
+		return map[string]interface{}{"key": f.Property, rangeType: bounds}
+	default:
+		clause := map[models.FilterOperator]string{
+			models.FilterAnd: "must",
+			models.FilterOr:  "should",
+			models.FilterNot: "must_not",
+		}[f.Operator]
+		operands := make([]interface{}, 0, len(f.Operands))
+		for _, operand := range f.Operands {
+			operands = append(operands, qdrantCondition(operand))
+		}
+		return map[string]interface{}{clause: operands}
+	}
+}
@@ -0,0 +1,181 @@
+package vectorstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Environment variables used to select and configure the vector store.
+const (
+	StoreEnv       = "VECTOR_STORE"
+	StorePathEnv   = "VECTOR_STORE_PATH"
+	StoreMetricEnv = "VECTOR_STORE_METRIC"
+	PGVectorURLEnv = "PGVECTOR_URL"
+	PGVectorDimEnv = "PGVECTOR_DIMENSIONS"
+
+	// DefaultStore is used when no store is configured.
+	DefaultStore = "weaviate"
+	// DefaultLocalStorePath is the index file of the local store when VECTOR_STORE_PATH isn't set.
+	DefaultLocalStorePath = "intellichunk.hnsw"
+)
+
+// Provider opens a VectorStore configured from the environment.
+type Provider func() (VectorStore, error)
+
+var (
+	storesMu sync.RWMutex
+	stores   = make(map[string]Provider)
+)
+
+func init() {
+	Register("weaviate", func() (VectorStore, error) {
+		return NewWeaviateStore(), nil
+	})
+	Register("memory", openSharedMemoryStore)
+	Register("local", openSharedLocalStore)
+	Register("pgvector", openSharedPGVectorStore)
+	Register("qdrant", func() (VectorStore, error) {
+		metric, err := ParseDistanceMetric(os.Getenv(StoreMetricEnv))
+		if err != nil {
+			return nil, err
+		}
+		return NewQdrantStore(WithQdrantMetric(metric)), nil
+	})
+}
+
+// Register makes a store available by name. Registering the same name twice replaces the previous store.
+func Register(name string, provider Provider) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	if provider == nil {
+		panic("vectorstore: Register provider is nil")
+	}
+	stores[name] = provider
+}
+
+// Stores returns the sorted names of the registered stores.
+func Stores() []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the store registered under name.
+func Open(name string) (VectorStore, error) {
+	storesMu.RLock()
+	provider, ok := stores[name]
+	storesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown vector store %q (registered: %v)", name, Stores())
+	}
+	return provider()
+}
+
+// New opens the store configured by VECTOR_STORE, Weaviate by default.
+func New() (VectorStore, error) {
+	name := os.Getenv(StoreEnv)
+	if name == "" {
+		name = DefaultStore
+	}
+	return Open(name)
+}
+
+var (
+	sharedMemoryOnce  sync.Once
+	sharedMemoryStore *MemoryStore
+	sharedMemoryErr   error
+)
+
+// openSharedMemoryStore returns the process wide MemoryStore, so everything added is visible to later searches.
+// It is snapshotted to VECTOR_STORE_PATH when set.
+func openSharedMemoryStore() (VectorStore, error) {
+	sharedMemoryOnce.Do(func() {
+		var metric DistanceMetric
+		metric, sharedMemoryErr = ParseDistanceMetric(os.Getenv(StoreMetricEnv))
+		if sharedMemoryErr != nil {
+			return
+		}
+		sharedMemoryStore, sharedMemoryErr = NewMemoryStore(
+			WithMetric(metric),
+			WithSnapshotPath(os.Getenv(StorePathEnv)),
+		)
+	})
+	if sharedMemoryErr != nil {
+		return nil, sharedMemoryErr
+	}
+	return sharedMemoryStore, nil
+}
+
+var (
+	sharedLocalOnce  sync.Once
+	sharedLocalStore *LocalStore
+	sharedLocalErr   error
+)
+
+// openSharedLocalStore returns the process wide LocalStore persisted at VECTOR_STORE_PATH.
+func openSharedLocalStore() (VectorStore, error) {
+	sharedLocalOnce.Do(func() {
+		path := os.Getenv(StorePathEnv)
+		if path == "" {
+			path = DefaultLocalStorePath
+		}
+		var metric DistanceMetric
+		metric, sharedLocalErr = ParseDistanceMetric(os.Getenv(StoreMetricEnv))
+		if sharedLocalErr != nil {
+			return
+		}
+		sharedLocalStore, sharedLocalErr = NewLocalStore(path, WithLocalMetric(metric))
+	})
+	if sharedLocalErr != nil {
+		return nil, sharedLocalErr
+	}
+	return sharedLocalStore, nil
+}
+
+var (
+	sharedPGVectorOnce  sync.Once
+	sharedPGVectorStore *PGVectorStore
+	sharedPGVectorErr   error
+)
+
+// openSharedPGVectorStore returns the process wide PGVectorStore connected to PGVECTOR_URL.
+func openSharedPGVectorStore() (VectorStore, error) {
+	sharedPGVectorOnce.Do(func() {
+		dsn := os.Getenv(PGVectorURLEnv)
+		if dsn == "" {
+			sharedPGVectorErr = errors.New(PGVectorURLEnv + " is not set")
+			return
+		}
+		var metric DistanceMetric
+		metric, sharedPGVectorErr = ParseDistanceMetric(os.Getenv(StoreMetricEnv))
+		if sharedPGVectorErr != nil {
+			return
+		}
+		options := []PGVectorOption{WithPGMetric(metric)}
+		if dim := os.Getenv(PGVectorDimEnv); dim != "" {
+			var dimensions int
+			dimensions, sharedPGVectorErr = strconv.Atoi(dim)
+			if sharedPGVectorErr != nil {
+				return
+			}
+			options = append(options, WithDimensions(dimensions))
+		}
+		sharedPGVectorStore, sharedPGVectorErr = NewPGVectorStore(dsn, options...)
+	})
+	if sharedPGVectorErr != nil {
+		return nil, sharedPGVectorErr
+	}
+	return sharedPGVectorStore, nil
+}
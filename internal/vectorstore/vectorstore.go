@@ -5,35 +5,61 @@ package vectorstore
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/apsystole/log"
 	"github.com/cckalen/intellichunk/internal/models"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/auth"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
 	wmodels "github.com/weaviate/weaviate/entities/models"
 )
 
+const _openAIVectorizer = "text2vec-openai"
+
 // VectorStore is an abstraction of a vector database.
 // This interface makes it easier to test the code and swap the underlying implementation.
 type VectorStore interface {
-	CheckOrCreateClass(className string) error
-	AddObjects(className string, objects []models.ContainerNodeVector, weaviateKey string) (objIDs []string, err error)
+	CheckAndCreateClass(className string) error
+	AddNodeObjects(className string, objects []models.ContainerNodeVector) (objIDs []string, err error)
 	AddGenericObjects(className string, objects []models.GeneralDataHolder) (objIDs []string, err error)
 	DeleteObjectByID(className, objectID string) (err error)
-	GetObjects(weaviateKey string, openAIKey string, className string, graphFieldNames []string, withLimit int) (interface{}, error)
-	SimilaritySearch(weaviateKey string, openAIKey string, className string, input string, graphFieldNames []string, withLimit int) ([]map[string]interface{}, error)
+	GetObjects(className string, graphFieldNames []string, withLimit int) (interface{}, error)
+	SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int, opts ...SearchOption) ([]SearchResult, error)
+}
+
+// SearchResult is an object found by SimilaritySearch.
+type SearchResult struct {
+	// ID is the id of the object in the store, as returned by AddNodeObjects.
+	ID string `json:"id"`
+	// Distance between the object and the query vector in the metric of the store, lower is closer.
+	Distance float32 `json:"distance"`
+	// Score ranks the results, higher is better. It is the similarity of the metric,
+	// e.g. the cosine similarity, or the fused score of hybrid searches.
+	Score float32 `json:"score"`
+	// Properties holds the requested graph field names.
+	Properties map[string]interface{} `json:"properties"`
+	// Vector of the object, only set with WithVectors.
+	Vector []float32 `json:"vector,omitempty"`
 }
 
+var _ VectorStore = WeaviateStore{}
+
 // WeaviateStore is a Weaviate implementation of the VectorStore interface.
 type WeaviateStore struct {
 	Host        string
 	Scheme      string
 	WeaviateKey string
 	OpenAIKey   string
+	Vectorizer  string
 }
 
 // Option is a function that can modify the WeaviateStore configuration.
@@ -67,6 +93,14 @@ func WithOpenAIKey(key string) Option {
 	}
 }
 
+// WithVectorizer sets the vectorizer module used when a class is created.
+// Use "none" when every vector is provided by the client, e.g. with a local embedding model.
+func WithVectorizer(vectorizer string) Option {
+	return func(store *WeaviateStore) {
+		store.Vectorizer = vectorizer
+	}
+}
+
 // NewWeaviateStore creates a new instance of WeaviateStore with optional configurations.
 func NewWeaviateStore(options ...Option) *WeaviateStore {
 	store := &WeaviateStore{
@@ -74,6 +108,10 @@ func NewWeaviateStore(options ...Option) *WeaviateStore {
 		Scheme:      "https",
 		WeaviateKey: os.Getenv("WEAVIATE_API_KEY"),
 		OpenAIKey:   os.Getenv("OPENAI_API_KEY"),
+		Vectorizer:  os.Getenv("WEAVIATE_VECTORIZER"),
+	}
+	if store.Vectorizer == "" {
+		store.Vectorizer = _openAIVectorizer
 	}
 
 	for _, option := range options {
@@ -83,6 +121,73 @@ func NewWeaviateStore(options ...Option) *WeaviateStore {
 	return store
 }
 
+// SearchOptions holds the optional parameters of a similarity search.
+type SearchOptions struct {
+	// QueryVector is searched instead of vectorizing the input on the server.
+	QueryVector []float32
+	// Filter restricts the objects that can be returned.
+	Filter *models.Filter
+	// Hybrid combines a keyword search with the vector search when set.
+	Hybrid *HybridOptions
+	// MaxDistance drops the objects further from the query, no limit when nil.
+	MaxDistance *float32
+	// Vectors returns the vectors of the objects.
+	Vectors bool
+}
+
+// SearchOption is a function that can modify a similarity search.
+type SearchOption func(*SearchOptions)
+
+// WithQueryVector searches with a vector computed by the client, so the store doesn't need its own vectorizer.
+func WithQueryVector(vector []float32) SearchOption {
+	return func(o *SearchOptions) {
+		o.QueryVector = vector
+	}
+}
+
+// WithMaxDistance drops the objects further than distance from the query, in the metric of the store.
+// In hybrid searches it only drops them from the vector ranking, the keyword matches are kept whatever their distance.
+func WithMaxDistance(distance float32) SearchOption {
+	return func(o *SearchOptions) {
+		o.MaxDistance = &distance
+	}
+}
+
+// WithVectors returns the vectors of the objects in the results, e.g. to diversify them.
+func WithVectors() SearchOption {
+	return func(o *SearchOptions) {
+		o.Vectors = true
+	}
+}
+
+// withinMaxDistance returns the results allowed by MaxDistance.
+func withinMaxDistance(results []SearchResult, options *SearchOptions) []SearchResult {
+	if options.MaxDistance == nil {
+		return results
+	}
+	kept := make([]SearchResult, 0, len(results))
+	for _, result := range results {
+		if result.Distance <= *options.MaxDistance {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+// finishResults keeps the requested fields of the results and drops their vectors unless requested.
+func finishResults(results []SearchResult, graphFieldNames []string, options *SearchOptions) []SearchResult {
+	for i := range results {
+		results[i].Properties = selectFields(results[i].Properties, graphFieldNames)
+		if options.Vectors {
+			// In-process stores share their vectors, callers get their own copy.
+			results[i].Vector = append([]float32(nil), results[i].Vector...)
+		} else {
+			results[i].Vector = nil
+		}
+	}
+	return results
+}
+
 // convertNamesToFields converts field names to graphql.Field.
 func convertNamesToFields(names []string) []graphql.Field {
 	fields := make([]graphql.Field, 0, len(names))
@@ -129,16 +234,32 @@ func (store WeaviateStore) GetObjects(className string, graphFieldNames []string
 //   - input: The query or concept to search for similarities.
 //   - graphFieldNames: The field names in the Weaviate objects to consider for the search.
 //   - withLimit: The maximum number of similar results to retrieve.
+//   - opts: Optional search parameters. WithQueryVector searches with nearVector instead of nearText,
+//     WithFilter restricts the results with a where filter, WithHybrid adds a BM25 search to the ranking,
+//     WithMaxDistance sets the distance threshold and WithVectors returns the object vectors.
 //
 // Returns:
-//   - An array of maps, where each map represents a relevant object found in Weaviate.
-//     Each map contains the selected graph field names as keys and their corresponding values.
+//   - The results, closest first. Each one has the object id, its distance and score,
+//     and the selected graph field names as property keys with their corresponding values.
 //   - An error if the search or retrieval process fails.
 //
 // Note: The function uses the Weaviate client and the GraphQL Get method to perform the similarity search.
-// It constructs a nearText argument with the provided input and a distance threshold of 0.8 to find similar objects.
-// The resulting objects are extracted and transformed into a map-based structure for easier retrieval of desired fields.
-func (store WeaviateStore) SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int) ([]map[string]interface{}, error) {
+// It constructs a nearText or nearVector argument with the provided input, and reads the id and distance from _additional.
+func (store WeaviateStore) SimilaritySearch(className string, input string, graphFieldNames []string, withLimit int, opts ...SearchOption) ([]SearchResult, error) {
+	options := &SearchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := validateSearchFilter(options); err != nil {
+		return nil, err
+	}
+	var where *filters.WhereBuilder
+	if options.Filter != nil {
+		var err error
+		if where, err = weaviateWhere(*options.Filter, false); err != nil {
+			return nil, err
+		}
+	}
 
 	cfg := weaviate.Config{
 		Host:       store.Host,
@@ -152,19 +273,218 @@ func (store WeaviateStore) SimilaritySearch(className string, input string, grap
 		return nil, err
 	}
 
-	fields := convertNamesToFields(graphFieldNames)
+	graphFieldNames = store.classFields(client, className, graphFieldNames)
+	limit := withLimit
+	if options.Hybrid != nil {
+		limit = hybridPoolSize(withLimit)
+	}
 
-	concepts := []string{input}
-	nearText := client.GraphQL().NearTextArgBuilder().
-		WithConcepts(concepts).
-		WithDistance(0.8)
+	results, err := store.search(client, className, graphFieldNames, limit, "distance", options, where, func(get *graphql.GetBuilder) *graphql.GetBuilder {
+		return withNearVectorOrText(client, get, input, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Score = Cosine.Similarity(results[i].Distance)
+	}
 
-	result, err := client.GraphQL().Get().
+	// The rankings are fused in the client so both fusions behave the same on every Weaviate version and every store.
+	if options.Hybrid != nil {
+		keyword, err := store.search(client, className, graphFieldNames, limit, "score", options, where, func(get *graphql.GetBuilder) *graphql.GetBuilder {
+			return get.WithBM25(client.GraphQL().Bm25ArgBuilder().WithQuery(input).WithProperties(HybridProperties...))
+		})
+		if err != nil {
+			return nil, err
+		}
+		// MaxDistance only applies to the vector ranking, an exact keyword match may be far from the query.
+		if len(options.QueryVector) > 0 {
+			// Weaviate classes use the cosine distance by default.
+			for i := range keyword {
+				keyword[i].Distance = Cosine.Distance(options.QueryVector, keyword[i].Vector)
+			}
+		} else if err := store.keywordDistances(client, className, input, results, keyword, options); err != nil {
+			return nil, err
+		}
+		results = fuseRankings(results, keyword, *options.Hybrid, withLimit)
+	}
+
+	return finishResults(results, graphFieldNames, options), nil
+}
+
+// search runs a Get query restricted by where, unless it is nil, and configured by configure,
+// and reads the id, the additional metric and the vector of the objects.
+// The vectors are fetched when requested, or to compute the distance of BM25 matches to the query vector.
+func (store WeaviateStore) search(client *weaviate.Client, className string, graphFieldNames []string, limit int, metric string, options *SearchOptions, where *filters.WhereBuilder, configure func(*graphql.GetBuilder) *graphql.GetBuilder) ([]SearchResult, error) {
+	additional := []graphql.Field{{Name: "id"}, {Name: metric}}
+	if options.Vectors || (metric == "score" && len(options.QueryVector) > 0) {
+		additional = append(additional, graphql.Field{Name: "vector"})
+	}
+	fields := append(convertNamesToFields(graphFieldNames), graphql.Field{Name: "_additional", Fields: additional})
+
+	get := client.GraphQL().Get().
 		WithClassName(className).
 		WithFields(fields...).
-		WithNearText(nearText).
-		WithLimit(withLimit).
-		Do(context.Background())
+		WithLimit(limit)
+	if where != nil {
+		get = get.WithWhere(where)
+	}
+
+	objectList, err := weaviateGet(configure(get), className)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(objectList))
+	for _, rawMap := range objectList {
+		additionalMap, _ := rawMap["_additional"].(map[string]interface{})
+		result := SearchResult{Properties: rawMap}
+		result.ID, _ = additionalMap["id"].(string)
+		if metric == "distance" {
+			result.Distance = float32(weaviateNumber(additionalMap["distance"]))
+		} else {
+			result.Score = float32(weaviateNumber(additionalMap["score"]))
+		}
+		if rawVector, ok := additionalMap["vector"].([]interface{}); ok {
+			result.Vector = make([]float32, 0, len(rawVector))
+			for _, v := range rawVector {
+				result.Vector = append(result.Vector, float32(weaviateNumber(v)))
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// keywordDistances sets the distances to the input of the keyword matches, searched without a query vector.
+// Weaviate vectorizes the input itself, so the distances of the matches missing from the vector ranking
+// come from a nearText search restricted to their ids.
+func (store WeaviateStore) keywordDistances(client *weaviate.Client, className, input string, vector, keyword []SearchResult, options *SearchOptions) error {
+	distances := make(map[string]float32, len(vector))
+	for _, result := range vector {
+		distances[result.ID] = result.Distance
+	}
+	var missing []*filters.WhereBuilder
+	for _, result := range keyword {
+		if _, ok := distances[result.ID]; !ok {
+			missing = append(missing, filters.Where().WithPath([]string{"id"}).WithOperator(filters.Equal).WithValueString(result.ID))
+		}
+	}
+
+	if len(missing) > 0 {
+		// The matches already passed the filter, and their distances are wanted whatever MaxDistance.
+		unbounded := &SearchOptions{}
+		where := missing[0]
+		if len(missing) > 1 {
+			where = filters.Where().WithOperator(filters.Or).WithOperands(missing)
+		}
+		results, err := store.search(client, className, nil, len(missing), "distance", unbounded, nil, func(get *graphql.GetBuilder) *graphql.GetBuilder {
+			return withNearVectorOrText(client, get, input, unbounded).WithWhere(where)
+		})
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			distances[result.ID] = result.Distance
+		}
+	}
+
+	for i := range keyword {
+		if distance, ok := distances[keyword[i].ID]; ok {
+			keyword[i].Distance = distance
+		} else {
+			keyword[i].Distance = math.MaxFloat32
+		}
+	}
+	return nil
+}
+
+// _classFieldsTTL is how long the properties of a class are cached. Other processes may add properties in the meantime,
+// so the cache expires instead of living as long as the process.
+const _classFieldsTTL = time.Minute
+
+// classProperties caches the properties of the classes, keyed by the Weaviate instance and class name.
+var classProperties = struct {
+	sync.Mutex
+	entries map[string]classPropertiesEntry
+}{entries: make(map[string]classPropertiesEntry)}
+
+type classPropertiesEntry struct {
+	properties map[string]bool
+	fetched    time.Time
+}
+
+// classKey identifies a class of the Weaviate instance of the store in the classProperties cache.
+func (store WeaviateStore) classKey(className string) string {
+	return store.Scheme + "://" + store.Host + "/" + className
+}
+
+// forgetClassFields drops the cached properties of the class, after objects that may add properties are stored.
+func (store WeaviateStore) forgetClassFields(className string) {
+	classProperties.Lock()
+	defer classProperties.Unlock()
+	delete(classProperties.entries, store.classKey(className))
+}
+
+// classFields returns the graph field names the class has properties for. Weaviate fails the queries of properties
+// its schema lacks, and the classes only get the properties of the objects added to them, e.g. not the provenance
+// of the nodes for the classes added before it. The properties are cached for _classFieldsTTL, and the names are
+// kept when the schema can't be read or has none of them.
+func (store WeaviateStore) classFields(client *weaviate.Client, className string, graphFieldNames []string) []string {
+	key := store.classKey(className)
+	classProperties.Lock()
+	entry, ok := classProperties.entries[key]
+	classProperties.Unlock()
+	if !ok || time.Since(entry.fetched) > _classFieldsTTL {
+		class, err := client.Schema().ClassGetter().WithClassName(className).Do(context.Background())
+		if err != nil || class == nil {
+			return graphFieldNames
+		}
+		entry = classPropertiesEntry{properties: make(map[string]bool, len(class.Properties)), fetched: time.Now()}
+		for _, property := range class.Properties {
+			entry.properties[property.Name] = true
+		}
+		classProperties.Lock()
+		classProperties.entries[key] = entry
+		classProperties.Unlock()
+	}
+
+	fields := make([]string, 0, len(graphFieldNames))
+	for _, name := range graphFieldNames {
+		if entry.properties[name] {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		// A query needs at least one field, the class may not have its properties yet.
+		return graphFieldNames
+	}
+	return fields
+}
+
+// withNearVectorOrText searches near the query vector when there is one, otherwise near the input vectorized by Weaviate.
+func withNearVectorOrText(client *weaviate.Client, get *graphql.GetBuilder, input string, options *SearchOptions) *graphql.GetBuilder {
+	if len(options.QueryVector) > 0 {
+		nearVector := client.GraphQL().NearVectorArgBuilder().
+			WithVector(options.QueryVector)
+		if options.MaxDistance != nil {
+			nearVector = nearVector.WithDistance(*options.MaxDistance)
+		}
+		return get.WithNearVector(nearVector)
+	}
+
+	concepts := []string{input}
+	nearText := client.GraphQL().NearTextArgBuilder().
+		WithConcepts(concepts)
+	if options.MaxDistance != nil {
+		nearText = nearText.WithDistance(*options.MaxDistance)
+	}
+	return get.WithNearText(nearText)
+}
+
+// weaviateGet runs a GraphQL Get query and returns the objects of className.
+func weaviateGet(get *graphql.GetBuilder, className string) ([]map[string]interface{}, error) {
+	result, err := get.Do(context.Background())
 
 	if err != nil {
 		log.Errorf("Failed to perform GraphQL Get: %v", err)
@@ -186,33 +506,105 @@ func (store WeaviateStore) SimilaritySearch(className string, input string, grap
 		return nil, nil
 	}
 
-	objects := make([]map[string]interface{}, len(objectList))
-
-	for i, rawObj := range objectList {
+	objects := make([]map[string]interface{}, 0, len(objectList))
+	for _, rawObj := range objectList {
 		rawMap, ok := rawObj.(map[string]interface{})
 		if !ok {
 			// we silently continue if this type assertion fails
 			continue
 		}
+		objects = append(objects, rawMap)
+	}
+	return objects, nil
+}
 
-		// Create a map to store the desired fields
-		object := make(map[string]interface{})
+// weaviateNumber reads an _additional number, which Weaviate returns as a string for BM25 scores.
+func weaviateNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	default:
+		return 0
+	}
+}
 
-		// Loop over the desired fields and extract them from the rawMap
-		for _, fieldName := range graphFieldNames {
-			fieldValue, ok := rawMap[fieldName]
-			if !ok {
-				// we silently continue if this type assertion fails
-				continue
-			}
+// weaviateWhere translates a validated filter to a Weaviate where filter.
+// Weaviate has no usable Not operator, so negations are pushed down to the leaves with De Morgan's laws.
+// A negated leaf over an array property is rejected: Weaviate matches it when some element differs,
+// where the filter means that no element matches.
+func weaviateWhere(f models.Filter, negate bool) (*filters.WhereBuilder, error) {
+	if negate && arrayProperties[f.Property] {
+		return nil, fmt.Errorf("weaviate can't negate a %s filter over the array property %q", f.Operator, f.Property)
+	}
 
-			object[fieldName] = fieldValue
+	switch f.Operator {
+	case models.FilterEq:
+		operator := filters.Equal
+		if negate {
+			operator = filters.NotEqual
 		}
-
-		objects[i] = object
+		return weaviateLeaf(f.Property, operator, f.Value), nil
+	case models.FilterIn:
+		operands := make([]models.Filter, 0, len(f.Values))
+		for _, value := range f.Values {
+			operands = append(operands, models.Eq(f.Property, value))
+		}
+		return weaviateWhere(models.Or(operands...), negate)
+	case models.FilterRange:
+		minOperator, maxOperator, combine := filters.GreaterThanEqual, filters.LessThanEqual, filters.And
+		if negate {
+			minOperator, maxOperator, combine = filters.LessThan, filters.GreaterThan, filters.Or
+		}
+		var operands []*filters.WhereBuilder
+		if f.Min != nil {
+			operands = append(operands, weaviateLeaf(f.Property, minOperator, f.Min))
+		}
+		if f.Max != nil {
+			operands = append(operands, weaviateLeaf(f.Property, maxOperator, f.Max))
+		}
+		if len(operands) == 1 {
+			return operands[0], nil
+		}
+		return filters.Where().WithOperator(combine).WithOperands(operands), nil
+	case models.FilterAnd, models.FilterOr:
+		combine := filters.And
+		if (f.Operator == models.FilterOr) != negate {
+			combine = filters.Or
+		}
+		operands := make([]*filters.WhereBuilder, 0, len(f.Operands))
+		for _, operand := range f.Operands {
+			where, err := weaviateWhere(operand, negate)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, where)
+		}
+		return filters.Where().WithOperator(combine).WithOperands(operands), nil
+	default: // models.FilterNot
+		return weaviateWhere(f.Operands[0], !negate)
 	}
+}
 
-	return objects, nil
+// weaviateLeaf builds a where filter comparing property to value with the value type Weaviate expects.
+func weaviateLeaf(property string, operator filters.WhereOperator, value interface{}) *filters.WhereBuilder {
+	where := filters.Where().WithPath([]string{property}).WithOperator(operator)
+	if n, ok := toFloat(value); ok {
+		return where.WithValueNumber(n)
+	}
+	if t, ok := toTime(value); ok {
+		return where.WithValueDate(t)
+	}
+	switch v := value.(type) {
+	case bool:
+		return where.WithValueBoolean(v)
+	case string:
+		return where.WithValueText(v)
+	default:
+		return where.WithValueText(fmt.Sprint(v))
+	}
 }
 
 // AddObjects adds the provided ContainerNodeVector objects to the specified Weaviate class in batch mode. The objects are represented
@@ -235,26 +627,9 @@ func (store WeaviateStore) AddNodeObjects(className string, objects []models.Con
 
 	batcher := client.Batch().ObjectsBatcher()
 	for _, obj := range objects {
-		properties := make(map[string]interface{})
-		objValue := reflect.ValueOf(obj)
-		objType := reflect.TypeOf(obj)
-		for i := 0; i < objValue.NumField(); i++ {
-			field := objValue.Field(i)
-			fieldInfo := objType.Field(i)
-			jsonTag := fieldInfo.Tag.Get("json")
-			// Check if there's a valid JSON tag, and use only the part before any comma
-			if jsonTag != "" {
-				jsonTag = strings.Split(jsonTag, ",")[0]
-			}
-			// Exclude the "embedding" field from properties
-			if jsonTag != "embedding" {
-				properties[jsonTag] = field.Interface()
-			}
-		}
-
 		weaviateObject := &wmodels.Object{
 			Class:      className,
-			Properties: properties,
+			Properties: nodeProperties(obj),
 			Vector:     obj.Embedding,
 		}
 
@@ -274,12 +649,37 @@ func (store WeaviateStore) AddNodeObjects(className string, objects []models.Con
 		return objIDs, err
 	}
 
+	// The objects may have added properties to the class
+	store.forgetClassFields(className)
+
 	for _, res := range result {
 		objIDs = append(objIDs, res.ID.String())
 	}
 	return objIDs, nil
 }
 
+// nodeProperties maps the fields of a ContainerNodeVector to properties named after their JSON tags.
+// The embedding is excluded since it is stored as the object's vector, and nil pointers since the node doesn't have them.
+func nodeProperties(obj models.ContainerNodeVector) map[string]interface{} {
+	properties := make(map[string]interface{})
+	objValue := reflect.ValueOf(obj)
+	objType := reflect.TypeOf(obj)
+	for i := 0; i < objValue.NumField(); i++ {
+		field := objValue.Field(i)
+		fieldInfo := objType.Field(i)
+		jsonTag := fieldInfo.Tag.Get("json")
+		// Check if there's a valid JSON tag, and use only the part before any comma
+		if jsonTag != "" {
+			jsonTag = strings.Split(jsonTag, ",")[0]
+		}
+		// Exclude the "embedding" field from properties, and the nil fields the node doesn't have
+		if jsonTag != "embedding" && !(field.Kind() == reflect.Ptr && field.IsNil()) {
+			properties[jsonTag] = field.Interface()
+		}
+	}
+	return properties
+}
+
 // CheckAndCreateClass checks if the given class exists in the Weaviate database. If the class does not exist,
 // it creates the class with the specified className and the required schema for text-based vectorization.
 // It utilizes the Weaviate client and the GraphQL Get method to perform the existence check and creation.
@@ -317,14 +717,16 @@ func (store WeaviateStore) CheckAndCreateClass(className string) error {
 	// If Class doesn't exist, create one
 	classObj := &wmodels.Class{
 		Class:      className,
-		Vectorizer: "text2vec-openai", // If set to "none" you must always provide vectors yourself. Could be any other "text2vec-*" also.
-		ModuleConfig: map[string]interface{}{
-			"text2vec-openai": map[string]interface{}{
+		Vectorizer: store.Vectorizer, // If set to "none" you must always provide vectors yourself. Could be any other "text2vec-*" also.
+	}
+	if store.Vectorizer == _openAIVectorizer {
+		classObj.ModuleConfig = map[string]interface{}{
+			_openAIVectorizer: map[string]interface{}{
 				"model":        "ada",
 				"modelVersion": "002",
 				"type":         "text",
 			},
-		},
+		}
 	}
 	// add the schema
 	err = client.Schema().ClassCreator().WithClass(classObj).Do(context.Background())